@@ -1,29 +1,83 @@
 package multi_tier_caching
 
 import (
-	"sync"
+	"math"
+	"sort"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
-	dto "github.com/prometheus/client_model/go"
+)
+
+const (
+	// DefaultMaxTrackedKeys is the number of hottest keys monitored for TopK, migrations and GetFrequencyPerMinute
+	DefaultMaxTrackedKeys = 10000
+	// DefaultFrequencyHalfLife is the time after which a request counts half as much
+	DefaultFrequencyHalfLife = time.Minute
+	// DefaultFrequencyWindow is the period GetFrequency reports the request rate for
+	DefaultFrequencyWindow = time.Minute
 )
 
 type CacheAnalytics struct {
 	cacheHits      *prometheus.CounterVec
 	cacheMisses    prometheus.Counter
-	keyFrequency   *prometheus.GaugeVec
+	negativeHits   prometheus.Counter
+	negativeStores prometheus.Counter
+	trackedKeys    prometheus.Gauge
 	migrationTime  prometheus.Histogram
 	migrationCount *prometheus.CounterVec
-	mu             sync.RWMutex
-	keys           map[string]struct{}
+	frequency      *frequencySketch // Exponentially decayed request counts per key
+	lifetime       *frequencySketch // Request counts per key since start, never decayed
+	halfLife       time.Duration
+	window         time.Duration
+	hitters        *heavyHitters // Hottest keys, at most maxKeys
+	maxKeys        int
+	metricsCfg     MetricsConfig
+}
+
+// AnalyticsOption configures optional CacheAnalytics behaviour
+type AnalyticsOption func(*CacheAnalytics)
+
+// WithHalfLife sets the time after which a request counts half as much in the frequencies
+func WithHalfLife(halfLife time.Duration) AnalyticsOption {
+	return func(a *CacheAnalytics) {
+		if halfLife > 0 {
+			a.halfLife = halfLife
+		}
+	}
+}
+
+// WithTrackedKeys sets how many of the hottest keys are monitored
+func WithTrackedKeys(keys int) AnalyticsOption {
+	return func(a *CacheAnalytics) {
+		if keys > 0 {
+			a.maxKeys = keys
+		}
+	}
+}
+
+// WithAnalyticsMetrics sets the registry, namespace and labels of the analytics metrics
+func WithAnalyticsMetrics(metrics MetricsConfig) AnalyticsOption {
+	return func(a *CacheAnalytics) {
+		a.metricsCfg = metrics
+	}
+}
+
+// WithFrequencyWindow sets the period GetFrequency and GetFrequencyPerMinute report the request rate for
+func WithFrequencyWindow(window time.Duration) AnalyticsOption {
+	return func(a *CacheAnalytics) {
+		if window > 0 {
+			a.window = window
+		}
+	}
 }
 
 // LogHit records a cache hit for a specific layer and key, updating the corresponding metrics.
 func (a *CacheAnalytics) LogHit(layerName, key string) {
 	a.cacheHits.WithLabelValues(layerName).Inc()
-	a.keyFrequency.WithLabelValues(key).Inc()
-	a.mu.Lock()
-	defer a.mu.Unlock()
-	a.keys[key] = struct{}{}
+	a.frequency.Increment(key)
+	a.lifetime.Increment(key)
+	a.hitters.Add(key, layerName)
+	a.trackedKeys.Set(float64(a.hitters.Len()))
 }
 
 // LogMiss increments the cache miss counter.
@@ -31,40 +85,77 @@ func (a *CacheAnalytics) LogMiss() {
 	a.cacheMisses.Inc()
 }
 
-// GetFrequency retrieves the request frequency of a specific cache key.
+// LogNegativeHit increments the counter of requests answered by a negative cache entry.
+func (a *CacheAnalytics) LogNegativeHit() {
+	a.negativeHits.Inc()
+}
+
+// LogNegativeStore increments the counter of written negative cache entries.
+func (a *CacheAnalytics) LogNegativeStore() {
+	a.negativeStores.Inc()
+}
+
+// Forget stops monitoring a specific cache key among the hottest keys. Its counters in the
+// frequency sketches are shared with colliding keys, so they are left to decay: subtracting
+// them would undercount the other keys.
+func (a *CacheAnalytics) Forget(key string) {
+	a.hitters.Remove(key)
+	a.trackedKeys.Set(float64(a.hitters.Len()))
+}
+
+// GetFrequency retrieves the request frequency of a specific cache key: the decayed request
+// rate expressed in requests per frequency window, rounded. Estimates may be slightly too high.
 func (a *CacheAnalytics) GetFrequency(key string) int {
-	gauge, err := a.keyFrequency.GetMetricWithLabelValues(key)
-	if err != nil {
-		return 0
-	}
+	return a.perWindow(a.Rate(key))
+}
 
-	var metric dto.Metric
-	if err = gauge.Write(&metric); err != nil {
-		return 0
-	}
+// perWindow converts a rate in requests per second to requests per frequency window
+func (a *CacheAnalytics) perWindow(rate float64) int {
+	return int(math.Round(rate * a.window.Seconds()))
+}
 
-	return int(metric.Gauge.GetValue())
+// Rate returns the instantaneous request rate of the key in requests per second. For a key
+// requested at a steady rate r the decayed count converges to r*halfLife/ln2.
+func (a *CacheAnalytics) Rate(key string) float64 {
+	return a.frequency.Estimate(key) * math.Ln2 / a.halfLife.Seconds()
 }
 
-// GetFrequencyPerMinute returns a map containing the request frequency of all tracked keys.
-func (a *CacheAnalytics) GetFrequencyPerMinute() map[string]int {
-	result := make(map[string]int)
+// Count returns the number of requests of the key since the analytics were created
+func (a *CacheAnalytics) Count(key string) uint64 {
+	return uint64(a.lifetime.Estimate(key))
+}
 
-	// Create a copy of the keys for secure iteration
-	a.mu.RLock()
-	keysCopy := make([]string, 0, len(a.keys))
-	for key := range a.keys {
-		keysCopy = append(keysCopy, key)
-	}
-	a.mu.RUnlock()
-
-	for _, key := range keysCopy {
-		if gauge, err := a.keyFrequency.GetMetricWithLabelValues(key); err == nil {
-			var metric dto.Metric
-			if err = gauge.Write(&metric); err == nil {
-				result[key] = int(*metric.Gauge.Value)
-			}
+// GetFrequencyPerMinute returns a map containing the request frequency of the tracked keys,
+// per frequency window (a minute by default).
+func (a *CacheAnalytics) GetFrequencyPerMinute() map[string]int {
+	top := a.TopK(0)
+	result := make(map[string]int, len(top))
+	for _, stat := range top {
+		if freq := a.perWindow(stat.Rate); freq > 0 {
+			result[stat.Key] = freq
 		}
 	}
 	return result
 }
+
+// TopK returns up to n of the hottest keys, highest rate first, without scanning every key:
+// only the monitored heavy hitters are ranked. n <= 0 returns every monitored key.
+func (a *CacheAnalytics) TopK(n int) []KeyStat {
+	top := a.hitters.Top(n)
+	stats := make([]KeyStat, len(top))
+	for i, entry := range top {
+		stats[i] = KeyStat{
+			Key:   entry.key,
+			Rate:  a.Rate(entry.key),
+			Count: a.Count(entry.key),
+			Layer: entry.layer,
+		}
+	}
+	sort.SliceStable(stats, func(i, j int) bool { return stats[i].Rate > stats[j].Rate })
+	return stats
+}
+
+// TrackedKeys returns the number of monitored keys
+func (a *CacheAnalytics) TrackedKeys() int {
+	return a.hitters.Len()
+}
@@ -2,37 +2,54 @@ package multi_tier_caching
 
 import (
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
 // NewCacheAnalytics initializes and returns a new CacheAnalytics instance with Prometheus metrics.
-func NewCacheAnalytics() *CacheAnalytics {
-	return &CacheAnalytics{
-		cacheHits: promauto.NewCounterVec(prometheus.CounterOpts{
-			Name: "cache_hits_total",
-			Help: "Total cache hits per layer",
-		}, []string{"layer"}),
-
-		cacheMisses: promauto.NewCounter(prometheus.CounterOpts{
-			Name: "cache_misses_total",
-			Help: "Total cache misses",
-		}),
-
-		keyFrequency: promauto.NewGaugeVec(prometheus.GaugeOpts{
-			Name: "cache_key_frequency",
-			Help: "Request frequency for keys",
-		}, []string{"key"}),
-
-		migrationTime: promauto.NewHistogram(prometheus.HistogramOpts{
-			Name:    "cache_migration_duration_seconds",
-			Help:    "Time spent on data migration",
-			Buckets: []float64{0.1, 0.5, 1, 5},
-		}),
-
-		migrationCount: promauto.NewCounterVec(prometheus.CounterOpts{
-			Name: "cache_migration_operations_total",
-			Help: "Total migration operations",
-		}, []string{"status"}),
-		keys: make(map[string]struct{}),
+// Per-key request frequencies are kept in a sketch and never exported, only aggregates are.
+func NewCacheAnalytics(opts ...AnalyticsOption) *CacheAnalytics {
+	analytics := &CacheAnalytics{
+		halfLife: DefaultFrequencyHalfLife,
+		window:   DefaultFrequencyWindow,
+		maxKeys:  DefaultMaxTrackedKeys,
 	}
+	for _, opt := range opts {
+		opt(analytics)
+	}
+	analytics.initMetrics(analytics.metricsCfg)
+	analytics.lifetime = newFrequencySketch(DefaultSketchWidth, 0)
+	analytics.frequency = newFrequencySketch(DefaultSketchWidth, analytics.halfLife)
+	analytics.hitters = newHeavyHitters(analytics.maxKeys, analytics.halfLife)
+	return analytics
+}
+
+func (a *CacheAnalytics) initMetrics(metrics MetricsConfig) {
+	a.cacheHits = metrics.CounterVec(prometheus.CounterOpts{
+		Name: "cache_hits_total",
+		Help: "Total cache hits per layer",
+	}, []string{"layer"})
+	a.cacheMisses = metrics.Counter(prometheus.CounterOpts{
+		Name: "cache_misses_total",
+		Help: "Total cache misses",
+	})
+	a.negativeHits = metrics.Counter(prometheus.CounterOpts{
+		Name: "cache_negative_hits_total",
+		Help: "Total requests answered by a negative cache entry",
+	})
+	a.negativeStores = metrics.Counter(prometheus.CounterOpts{
+		Name: "cache_negative_entries_total",
+		Help: "Total negative cache entries written for keys absent from the database",
+	})
+	a.trackedKeys = metrics.Gauge(prometheus.GaugeOpts{
+		Name: "cache_tracked_keys",
+		Help: "Number of keys with recent requests tracked for migrations",
+	})
+	a.migrationTime = metrics.Histogram(prometheus.HistogramOpts{
+		Name:    "cache_migration_duration_seconds",
+		Help:    "Time spent on data migration",
+		Buckets: []float64{0.1, 0.5, 1, 5},
+	})
+	a.migrationCount = metrics.CounterVec(prometheus.CounterOpts{
+		Name: "cache_migration_operations_total",
+		Help: "Total migration operations",
+	}, []string{"status"})
 }
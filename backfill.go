@@ -0,0 +1,89 @@
+package multi_tier_caching
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// BackfillPolicy controls how a hit in a colder layer is copied into the hotter layers
+type BackfillPolicy int
+
+const (
+	// BackfillNone leaves promotion to the MigrationManager
+	BackfillNone BackfillPolicy = iota
+	// BackfillAlways synchronously copies the value into every hotter layer
+	BackfillAlways
+	// BackfillFrequency copies the value only into hotter layers whose frequency threshold the key reaches
+	BackfillFrequency
+	// BackfillAsync copies the value into every hotter layer in the background
+	BackfillAsync
+)
+
+func (p BackfillPolicy) String() string {
+	switch p {
+	case BackfillAlways:
+		return "always"
+	case BackfillFrequency:
+		return "frequency"
+	case BackfillAsync:
+		return "async"
+	default:
+		return "none"
+	}
+}
+
+// backfill copies an entry found in layer foundIndex into layers 0..foundIndex-1.
+// The copy keeps the entry metadata, so a stale value stays stale in the hotter layers.
+// An entry without metadata is copied as a fresh one.
+func (c *MultiTierCache) backfill(ctx context.Context, key string, entry Entry, foundIndex int) {
+	if foundIndex == 0 || c.backfillPolicy == BackfillNone {
+		return
+	}
+	if c.backfillPolicy == BackfillAsync {
+		// One backfill per key at a time, concurrent hits of the key would copy the same value
+		if _, running := c.backfilling.LoadOrStore(key, struct{}{}); running {
+			return
+		}
+		spawned := c.spawn(func() {
+			defer c.backfilling.Delete(key)
+			c.backfillLayers(context.WithoutCancel(ctx), key, entry, foundIndex)
+		})
+		if !spawned {
+			c.backfilling.Delete(key)
+		}
+		return
+	}
+	c.backfillLayers(ctx, key, entry, foundIndex)
+}
+
+func (c *MultiTierCache) backfillLayers(ctx context.Context, key string, entry Entry, foundIndex int) {
+	freq := c.analytics.GetFrequency(key)
+	ttl := time.Duration(c.ttlManager.calculateAdaptiveTTL(freq)) * time.Second
+	// Hotter layers must not keep the value longer than it is tracked for the key
+	if current := time.Duration(c.ttlManager.GetTTL(key)) * time.Second; current > 0 && current < ttl {
+		ttl = current
+	}
+
+	for i := 0; i < foundIndex; i++ {
+		if c.backfillPolicy == BackfillFrequency && freq < c.freqThresholds[i] {
+			continue
+		}
+		layerTTL := ttl
+		if i < len(c.backfillTTLs) && c.backfillTTLs[i] > 0 && c.backfillTTLs[i] < layerTTL {
+			layerTTL = c.backfillTTLs[i]
+		}
+		layer := c.layers[i]
+		layerEntry := entry
+		if entry.StoredAt.IsZero() && entry.SoftExpiry.IsZero() {
+			layerEntry = c.newEntry(entry.Value, layerTTL)
+		}
+		if err := c.writeLayerEntry(ctx, layer.Layer, key, layerEntry, layerTTL); err != nil {
+			log.Printf("[CACHE] Backfill of key=%s into %v failed: %v", key, layer.Name, err)
+			continue
+		}
+		if c.debug {
+			log.Printf("[CACHE] Backfilled key=%s into %v, TTL=%v", key, layer.Name, layerTTL)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package multi_tier_caching
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// bootstrapChunk is the number of scanned keys added to the filter at once
+const bootstrapChunk = 1024
+
+// ErrBootstrapRunning is returned by Bootstrap while another bootstrap of the filter runs
+var ErrBootstrapRunning = errors.New("bloom filter bootstrap already running")
+
+// BootstrapProgress reports how far the Bloom filter bootstrap got
+type BootstrapProgress struct {
+	Keys     int64     // Keys added to the filter so far
+	Started  time.Time // Zero when no bootstrap ran
+	Finished time.Time // Zero while the bootstrap runs
+	Err      error     // Why the bootstrap stopped early
+}
+
+// Running reports whether the bootstrap started and has not finished yet
+func (p BootstrapProgress) Running() bool {
+	return !p.Started.IsZero() && p.Finished.IsZero()
+}
+
+type bloomBootstrap struct {
+	mu         sync.Mutex
+	ready      chan struct{} // Closed once the running bootstrap finished, closed from the start without one
+	progress   BootstrapProgress
+	permissive atomic.Bool // Exists reports every key while set: the filter is incomplete
+}
+
+func newBloomBootstrap() *bloomBootstrap {
+	ready := make(chan struct{})
+	close(ready)
+	return &bloomBootstrap{ready: ready}
+}
+
+// Bootstrap adds every key of the scanner to the filter. Until it succeeds Exists reports every
+// key as possibly present, so keys that are not loaded yet are never reported as absent.
+// If the scan fails the filter stays permissive, it cannot tell which keys are missing.
+func (m *membership) Bootstrap(ctx context.Context, scanner KeyScanner) error {
+	if err := m.beginBootstrap(); err != nil {
+		return err
+	}
+	return m.runBootstrap(ctx, scanner)
+}
+
+// beginBootstrap makes the filter permissive and resets the readiness gate
+func (m *membership) beginBootstrap() error {
+	state := m.bootstrap
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	select {
+	case <-state.ready:
+	default:
+		return ErrBootstrapRunning
+	}
+	state.permissive.Store(true)
+	state.ready = make(chan struct{})
+	state.progress = BootstrapProgress{Started: time.Now()}
+	m.metrics.ready.Set(0)
+	m.metrics.bootstrapKeys.Set(0)
+	return nil
+}
+
+func (m *membership) runBootstrap(ctx context.Context, scanner KeyScanner) error {
+	// Keys lost from now on are not made up for by this scan
+	repairable, _ := m.filter.(repairableFilter)
+	var lost uint64
+	if repairable != nil {
+		lost, _ = repairable.losses()
+	}
+	chunk := make([]string, 0, bootstrapChunk)
+	flush := func() {
+		if len(chunk) == 0 {
+			return
+		}
+		if adder, ok := m.filter.(keysAdder); ok {
+			adder.AddKeys(chunk)
+		} else {
+			for _, key := range chunk {
+				m.Add(key)
+			}
+		}
+		m.bootstrapProgressed(len(chunk))
+		chunk = chunk[:0]
+	}
+
+	err := scanner.ScanKeys(ctx, func(key string) error {
+		chunk = append(chunk, key)
+		if len(chunk) == cap(chunk) {
+			flush()
+		}
+		return ctx.Err()
+	})
+	flush()
+	if err == nil && repairable != nil {
+		err = repairable.repair(lost)
+	}
+	m.finishBootstrap(err)
+	return err
+}
+
+func (m *membership) bootstrapProgressed(keys int) {
+	state := m.bootstrap
+	state.mu.Lock()
+	state.progress.Keys += int64(keys)
+	loaded := state.progress.Keys
+	state.mu.Unlock()
+	m.metrics.bootstrapKeys.Set(float64(loaded))
+	if m.debug {
+		log.Printf("[BLOOM] Bootstrap loaded %d keys", loaded)
+	}
+}
+
+func (m *membership) finishBootstrap(err error) {
+	state := m.bootstrap
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	state.progress.Finished = time.Now()
+	state.progress.Err = err
+	if err == nil {
+		state.permissive.Store(false)
+		m.metrics.ready.Set(1)
+	}
+	close(state.ready)
+	if m.debug {
+		log.Printf("[BLOOM] Bootstrap finished: %d keys in %v, error: %v",
+			state.progress.Keys, state.progress.Finished.Sub(state.progress.Started), err)
+	}
+}
+
+// Ready returns a channel closed once the running bootstrap finished. It is closed already
+// when no bootstrap runs.
+func (m *membership) Ready() <-chan struct{} {
+	m.bootstrap.mu.Lock()
+	defer m.bootstrap.mu.Unlock()
+	return m.bootstrap.ready
+}
+
+// BootstrapProgress returns the state of the last bootstrap
+func (m *membership) BootstrapProgress() BootstrapProgress {
+	m.bootstrap.mu.Lock()
+	defer m.bootstrap.mu.Unlock()
+	return m.bootstrap.progress
+}
+
+// startBloomBootstrap fills the Bloom filter from the database in the background. A non-zero
+// since limits the scan to the keys stored after since when the database implements
+// IncrementalKeyScanner, the filter already holds the older ones.
+func (c *MultiTierCache) startBloomBootstrap(ctx context.Context, since time.Time) {
+	var scanner KeyScanner
+	if incremental, ok := c.db.(IncrementalKeyScanner); ok && !since.IsZero() {
+		scanner = keysSince{db: incremental, since: since.Add(-snapshotCatchUpSlack)}
+	} else if scanner, ok = c.db.(KeyScanner); !ok {
+		log.Printf("[BLOOM] Bootstrap skipped: the database does not implement KeyScanner")
+		return
+	}
+	if err := c.filter.beginBootstrap(); err != nil {
+		log.Printf("[BLOOM] Bootstrap skipped: %v", err)
+		return
+	}
+	ctx, c.stopBootstrap = context.WithCancel(ctx)
+	c.spawn(func() {
+		if err := c.filter.runBootstrap(ctx, scanner); err != nil {
+			log.Printf("[BLOOM] Bootstrap failed, the filter no longer excludes keys: %v", err)
+		}
+	})
+}
+
+// WaitReady blocks until the Bloom filter bootstrap finished or the context is done.
+// It returns the bootstrap error, reads are served meanwhile without trusting the filter.
+func (c *MultiTierCache) WaitReady(ctx context.Context) error {
+	select {
+	case <-c.filter.Ready():
+		return c.filter.BootstrapProgress().Err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// BootstrapProgress reports how many keys the Bloom filter bootstrap has loaded
+func (c *MultiTierCache) BootstrapProgress() BootstrapProgress {
+	return c.filter.BootstrapProgress()
+}
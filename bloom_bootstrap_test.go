@@ -0,0 +1,117 @@
+package multi_tier_caching
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// scanTestStore is a batchTestStore that can list its keys once release is closed. Keys missing
+// from storedAt count as stored long ago.
+type scanTestStore struct {
+	*batchTestStore
+	release  chan struct{}
+	err      error
+	storedAt map[string]time.Time
+	since    []time.Time // Arguments of ScanKeysSince
+}
+
+func (s *scanTestStore) ScanKeys(ctx context.Context, fn func(key string) error) error {
+	return s.ScanKeysSince(ctx, time.Time{}, fn)
+}
+
+func (s *scanTestStore) ScanKeysSince(ctx context.Context, since time.Time, fn func(key string) error) error {
+	s.mu.Lock()
+	if !since.IsZero() {
+		s.since = append(s.since, since)
+	}
+	s.mu.Unlock()
+	select {
+	case <-s.release:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if s.err != nil {
+		return s.err
+	}
+	s.mu.Lock()
+	keys := make([]string, 0, len(s.data))
+	for key := range s.data {
+		if since.IsZero() || s.storedAt[key].After(since) {
+			keys = append(keys, key)
+		}
+	}
+	s.mu.Unlock()
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := fn(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newBootstrapTestCache(t *testing.T, db *scanTestStore) *MultiTierCache {
+	return newTestCache(t, MultiTierCacheConfig{
+		Layers:         []LayerInfo{NewLayerInfo(newBatchTestStore("hot", nil))},
+		DB:             db,
+		Thresholds:     []int{0},
+		BloomHashes:    3,
+		BloomBootstrap: true,
+	})
+}
+
+func TestMultiTierCache_BloomBootstrap(t *testing.T) {
+	ctx := context.Background()
+	db := &scanTestStore{
+		batchTestStore: newBatchTestStore("db", map[string]string{"key1": "value1", "key2": "value2", "key3": "value3"}),
+		release:        make(chan struct{}),
+	}
+	cache := newBootstrapTestCache(t, db)
+
+	// While the keys are loading the filter excludes nothing, stored rows stay readable
+	assert.True(t, cache.BootstrapProgress().Running())
+	value, err := cache.Get(ctx, "key1")
+	require.NoError(t, err)
+	assert.Equal(t, "value1", value)
+	_, err = cache.Get(ctx, "absent")
+	assert.ErrorIs(t, err, ErrCacheMiss)
+
+	waitCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, cache.WaitReady(waitCtx), context.DeadlineExceeded)
+
+	close(db.release)
+	require.NoError(t, cache.WaitReady(ctx))
+	progress := cache.BootstrapProgress()
+	assert.False(t, progress.Running())
+	assert.Equal(t, int64(3), progress.Keys)
+	assert.True(t, cache.filter.Exists("key2"))
+	assert.True(t, cache.filter.Exists("key3"))
+	assert.False(t, cache.filter.Exists("absent"))
+
+	assert.NoError(t, cache.filter.Bootstrap(ctx, db), "a finished bootstrap can be repeated")
+}
+
+func TestMultiTierCache_BloomBootstrapFailure(t *testing.T) {
+	ctx := context.Background()
+	db := &scanTestStore{
+		batchTestStore: newBatchTestStore("db", map[string]string{"key1": "value1"}),
+		release:        make(chan struct{}),
+		err:            errors.New("connection refused"),
+	}
+	cache := newBootstrapTestCache(t, db)
+	assert.ErrorIs(t, cache.filter.Bootstrap(ctx, db), ErrBootstrapRunning)
+
+	close(db.release)
+	assert.ErrorContains(t, cache.WaitReady(ctx), "connection refused")
+	assert.True(t, cache.filter.Exists("absent"), "an incomplete filter must not exclude keys")
+	value, err := cache.Get(ctx, "key1")
+	require.NoError(t, err)
+	assert.Equal(t, "value1", value)
+}
@@ -6,191 +6,134 @@ import (
 	"time"
 
 	"github.com/bits-and-blooms/bloom/v3"
-	"github.com/prometheus/client_golang/prometheus"
 )
 
+const (
+	// bloomGrowth multiplies the key capacity of every new stage
+	bloomGrowth = 2
+	// bloomTightening multiplies the false positive rate of every new stage, so the compound
+	// rate stays below the rate of the first stage divided by (1 - bloomTightening)
+	bloomTightening = 0.8
+)
+
+// bloomStage is one filter of the chain
+type bloomStage struct {
+	filter   *bloom.BloomFilter
+	capacity uint    // Keys the stage takes before a new stage is chained
+	count    uint    // Keys added to the stage
+	fpRate   float64 // False positive rate of the stage once it holds capacity keys
+}
+
+// BloomFilter is a scalable Bloom filter. Keys are added to the newest stage; once it holds
+// its capacity a larger stage with a lower false positive rate is chained. Stages are never
+// rebuilt or dropped, so a key that was added is always reported as possibly present.
+// It cannot forget keys, see CountingBloomFilter.
 type BloomFilter struct {
 	debug          bool
-	filter         *bloom.BloomFilter
+	stages         []*bloomStage
 	mu             sync.Mutex
-	analytics      *CacheAnalytics
-	increaseFactor float64
-	decreaseFactor float64
-	maxSize        uint
-	minSize        uint
 	lastAdjustment time.Time
-	stopChan       chan struct{}
 }
 
+// NewBloomFilter creates a filter whose first stage has size bits and hashFuncs hash functions.
+// The filter grows with the keys it holds, analytics is no longer used and may be nil.
 func NewBloomFilter(size uint, hashFuncs uint, debug bool, analytics *CacheAnalytics) *BloomFilter {
-	prometheus.MustRegister(
-		bloomCapacityGauge,
-		bloomCountGauge,
-		bloomHashFunctionsGauge,
-		bloomFalsePositiveGauge,
-		bloomLoadFactorGauge,
-		bloomLastAdjustmentGauge,
-	)
-	bloomFilter := &BloomFilter{
-		filter:         bloom.New(size, hashFuncs),
+	first := bloom.New(size, hashFuncs)
+	capacity := filterKeyCapacity(first.Cap(), first.K())
+	return &BloomFilter{
+		stages: []*bloomStage{{
+			filter:   first,
+			capacity: capacity,
+			fpRate:   estimateFalsePositiveRate(first.K(), first.Cap(), capacity),
+		}},
 		debug:          debug,
-		analytics:      analytics,
-		increaseFactor: 1.5,
-		decreaseFactor: 0.7,
-		maxSize:        100000,
-		minSize:        1000,
 		lastAdjustment: time.Now(),
-		stopChan:       make(chan struct{}),
 	}
-	go bloomFilter.metricsUpdater()
-	return bloomFilter
 }
 
 func (b *BloomFilter) Add(key string) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
-	b.filter.AddString(key)
+	b.addLocked(key)
 	if b.debug {
 		log.Printf("[BLOOM] Added key: %s", key)
 	}
-	// Checking the load and changing the filter size
-	b.adjustFilterSize()
 }
 
 func (b *BloomFilter) Exists(key string) bool {
 	b.mu.Lock()
 	defer b.mu.Unlock()
-	exists := b.filter.TestString(key)
+	exists := b.testLocked(key)
 	if b.debug {
 		log.Printf("[BLOOM] Check key=%s, exists=%v", key, exists)
 	}
 	return exists
 }
 
-func (b *BloomFilter) adjustFilterSize() {
-	if time.Since(b.lastAdjustment) < 20*time.Second {
-		if b.debug {
-			log.Printf("[BLOOM] Skipping adjustment, lastAdjustment was %v", b.lastAdjustment)
-		}
-		return // Adjust maximum once per minute
-	}
-
-	currentSize := b.filter.Cap()
-	metrics := b.analytics.GetFrequencyPerMinute()
-	totalRequests := 0
-	for _, v := range metrics {
-		totalRequests += v
-	}
-
-	// Check cache miss rate
-	misses := getCounterValue(b.analytics.cacheMisses)
-	hits := getCounterValueVec(b.analytics.cacheHits)
+// Remove does nothing, the bits of a key are shared with other keys
+func (b *BloomFilter) Remove(string) {}
 
-	// Calculate desired size based on metrics
-	desiredSize := uint(float64(currentSize) * b.calculateAdjustmentFactor(totalRequests, misses, hits))
-
-	// Apply boundaries
-	if desiredSize > b.maxSize {
-		desiredSize = b.maxSize
-	} else if desiredSize < b.minSize {
-		desiredSize = b.minSize
+// addLocked adds the key to the newest stage and chains a new stage once it is full.
+// The caller holds mu.
+func (b *BloomFilter) addLocked(key string) {
+	if b.testLocked(key) {
+		return // Already reported as present, adding it again would only fill the stage
 	}
-
-	if desiredSize != currentSize {
-		b.resizeFilter(desiredSize, metrics)
-		b.lastAdjustment = time.Now()
-		if b.debug {
-			log.Printf("[BLOOM] Resized filter to %d, lastAdjustment updated to %s", desiredSize, b.lastAdjustment.Format("15:04:05"))
-		}
+	stage := b.stages[len(b.stages)-1]
+	stage.filter.AddString(key)
+	stage.count++
+	if stage.count >= stage.capacity {
+		b.growLocked()
 	}
 }
 
-func (b *BloomFilter) calculateAdjustmentFactor(totalRequests int, misses float64, hits float64) float64 {
-	if totalRequests == 0 {
-		return 1.0
-	}
-
-	missRate := misses / (hits + misses)
-	loadFactor := float64(len(b.analytics.keys)) / float64(b.filter.Cap())
-
-	// Increase size if high miss rate or load factor > 0.75
-	if missRate > 0.1 || loadFactor > 0.75 {
-		return b.increaseFactor
-	}
-
-	// Decrease size if low utilization and miss rate
-	if loadFactor < 0.25 && missRate < 0.05 {
-		return b.decreaseFactor
+// testLocked reports whether any stage may contain the key. The caller holds mu.
+func (b *BloomFilter) testLocked(key string) bool {
+	// The newest stage is the largest and receives the recent keys, test it first
+	for i := len(b.stages) - 1; i >= 0; i-- {
+		if b.stages[i].filter.TestString(key) {
+			return true
+		}
 	}
-
-	return 1.0
+	return false
 }
 
-func (b *BloomFilter) resizeFilter(newSize uint, frequencies map[string]int) {
-	newFilter := bloom.New(newSize, b.filter.K())
-
-	// Dynamic threshold based on average number of requests
-	total := 0
-	for _, count := range frequencies {
-		total += count
-	}
-	avg := total / len(frequencies)
-	threshold := avg // or another coefficient, for example, avg / 2
-
-	for key, count := range frequencies {
-		if count > threshold {
-			newFilter.AddString(key)
-		}
+// growLocked chains a stage taking bloomGrowth times more keys at a lower false positive rate.
+// The caller holds mu.
+func (b *BloomFilter) growLocked() {
+	last := b.stages[len(b.stages)-1]
+	stage := &bloomStage{
+		capacity: last.capacity * bloomGrowth,
+		fpRate:   last.fpRate * bloomTightening,
 	}
-
-	b.mu.Lock()
-	defer b.mu.Unlock()
-	b.filter = newFilter
-
+	stage.filter = bloom.NewWithEstimates(stage.capacity, stage.fpRate)
+	b.stages = append(b.stages, stage)
+	b.lastAdjustment = time.Now()
 	if b.debug {
-		log.Printf("[BLOOM] Resized filter from %d to %d, kept %d keys",
-			b.filter.Cap(), newSize, len(frequencies))
+		log.Printf("[BLOOM] Added stage %d: %d keys, %d bits, %d hashes",
+			len(b.stages), stage.capacity, stage.filter.Cap(), stage.filter.K())
 	}
 }
 
-func (b *BloomFilter) metricsUpdater() {
-	ticker := time.NewTicker(5 * time.Second)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-ticker.C:
-			//b.adjustFilterSize()
-			b.UpdateMetrics()
-		case <-b.stopChan:
-			return
-		}
-	}
-}
-
-func (b *BloomFilter) UpdateMetrics() {
+// Stats sums the stages of the filter
+func (b *BloomFilter) Stats() FilterStats {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
-	capacity := float64(b.filter.Cap())
-	count := float64(b.filter.ApproximatedSize())
-	hashFuncs := float64(b.filter.K())
-	falsePositiveRate := estimateFalsePositiveRate(b.filter.K(), b.filter.Cap(), uint(count))
-	loadFactor := count / capacity
-	lastAdjustmentTS := float64(b.lastAdjustment.Unix())
-
-	bloomCapacityGauge.Set(capacity)
-	bloomCountGauge.Set(count)
-	bloomHashFunctionsGauge.Set(hashFuncs)
-	bloomFalsePositiveGauge.Set(falsePositiveRate)
-	bloomLoadFactorGauge.Set(loadFactor)
-	bloomLastAdjustmentGauge.Set(lastAdjustmentTS)
-
-	if b.debug {
-		log.Printf("[BLOOM] Metrics updated - "+
-			"Capacity: %.0f, Count: %.0f, Hashes: %.0f, "+
-			"FPR: %.6f, Load: %.4f, LastAdj: %d",
-			capacity, count, hashFuncs,
-			falsePositiveRate, loadFactor, int64(lastAdjustmentTS))
+	stats := FilterStats{
+		HashFunctions:  b.stages[len(b.stages)-1].filter.K(),
+		Stages:         len(b.stages),
+		LastAdjustment: b.lastAdjustment,
+	}
+	var keyCapacity uint
+	notFalsePositive := 1.0
+	for _, stage := range b.stages {
+		stats.Capacity += stage.filter.Cap()
+		stats.Count += stage.count
+		keyCapacity += stage.capacity
+		notFalsePositive *= 1 - estimateFalsePositiveRate(stage.filter.K(), stage.filter.Cap(), stage.count)
 	}
+	stats.FalsePositiveRate = 1 - notFalsePositive
+	stats.LoadFactor = float64(stats.Count) / float64(keyCapacity)
+	return stats
 }
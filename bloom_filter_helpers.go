@@ -3,43 +3,27 @@ package multi_tier_caching
 import (
 	"math"
 
-	"github.com/prometheus/client_golang/prometheus"
-	dto "github.com/prometheus/client_model/go"
+	"github.com/bits-and-blooms/bloom/v3"
 )
 
-// Helper function to get counter value
-func getCounterValue(c prometheus.Counter) float64 {
-	var metric dto.Metric
-	if err := c.Write(&metric); err != nil {
-		return 0
+func estimateFalsePositiveRate(k uint, m uint, n uint) float64 {
+	if m == 0 || n == 0 {
+		return 0.0
 	}
-	return metric.Counter.GetValue()
+	return math.Pow(1-math.Exp(-float64(k*n)/float64(m)), float64(k))
 }
 
-// Helper function to get sum of counter vector values
-func getCounterValueVec(vec *prometheus.CounterVec) float64 {
-	total := 0.0
-
-	// Collect all metrics from the counter vector
-	ch := make(chan prometheus.Metric, 10) // Buffered channel to prevent blocking
-	go func() {
-		vec.Collect(ch)
-		close(ch)
-	}()
-
-	for metric := range ch {
-		dtoMetric := &dto.Metric{}
-		if err := metric.Write(dtoMetric); err == nil {
-			total += dtoMetric.Counter.GetValue()
-		}
-	}
-
-	return total
+// filterKeyCapacity returns the keys a filter of m slots and k hash functions takes before
+// half of its slots are set, the fill of an optimally sized filter
+func filterKeyCapacity(m uint, k uint) uint {
+	return max(uint(float64(m)*math.Ln2/float64(max(k, 1))), 1)
 }
 
-func estimateFalsePositiveRate(k uint, m uint, n uint) float64 {
-	if m == 0 || n == 0 {
-		return 0.0
+// hashLocations returns the k slots of the key in a filter of m slots, several may coincide
+func hashLocations(key string, k uint, m uint64) []uint64 {
+	locations := bloom.Locations([]byte(key), k)
+	for i := range locations {
+		locations[i] %= m
 	}
-	return math.Pow(1-math.Exp(-float64(k*n)/float64(m)), float64(k))
+	return locations
 }
@@ -1,47 +1,72 @@
 package multi_tier_caching
 
-import "github.com/prometheus/client_golang/prometheus"
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
 
-var (
-	bloomCapacityGauge = prometheus.NewGauge(
-		prometheus.GaugeOpts{
-			Name: "bloom_filter_capacity",
-			Help: "Current capacity of the Bloom filter",
-		},
-	)
+// filterMetrics are the gauges of the membership filter, whichever implementation it is
+type filterMetrics struct {
+	capacity       prometheus.Gauge
+	count          prometheus.Gauge
+	hashFunctions  prometheus.Gauge
+	falsePositive  prometheus.Gauge
+	loadFactor     prometheus.Gauge
+	lastAdjustment prometheus.Gauge
+	bootstrapKeys  prometheus.Gauge
+	ready          prometheus.Gauge
+	stages         prometheus.Gauge
+}
 
-	bloomCountGauge = prometheus.NewGauge(
-		prometheus.GaugeOpts{
+func newFilterMetrics(metrics MetricsConfig) *filterMetrics {
+	return &filterMetrics{
+		capacity: metrics.Gauge(prometheus.GaugeOpts{
+			Name: "bloom_filter_capacity",
+			Help: "Bits or counters of the membership filter",
+		}),
+		count: metrics.Gauge(prometheus.GaugeOpts{
 			Name: "bloom_filter_element_count",
-			Help: "Approximate number of elements in the Bloom filter",
-		},
-	)
-
-	bloomHashFunctionsGauge = prometheus.NewGauge(
-		prometheus.GaugeOpts{
+			Help: "Approximate number of keys in the membership filter",
+		}),
+		hashFunctions: metrics.Gauge(prometheus.GaugeOpts{
 			Name: "bloom_filter_hash_functions",
-			Help: "Number of hash functions used in the Bloom filter",
-		},
-	)
-
-	bloomFalsePositiveGauge = prometheus.NewGauge(
-		prometheus.GaugeOpts{
+			Help: "Number of hash functions used in the membership filter",
+		}),
+		falsePositive: metrics.Gauge(prometheus.GaugeOpts{
 			Name: "bloom_filter_false_positive_rate",
-			Help: "Estimated false positive rate of the Bloom filter",
-		},
-	)
-
-	bloomLoadFactorGauge = prometheus.NewGauge(
-		prometheus.GaugeOpts{
+			Help: "Estimated false positive rate of the membership filter",
+		}),
+		loadFactor: metrics.Gauge(prometheus.GaugeOpts{
 			Name: "bloom_filter_load_factor",
-			Help: "Current load factor of the Bloom filter",
-		},
-	)
-
-	bloomLastAdjustmentGauge = prometheus.NewGauge(
-		prometheus.GaugeOpts{
+			Help: "Keys in the membership filter divided by the keys it takes",
+		}),
+		lastAdjustment: metrics.Gauge(prometheus.GaugeOpts{
 			Name: "bloom_filter_last_adjustment_timestamp",
-			Help: "Timestamp of last Bloom filter adjustment",
-		},
-	)
-)
+			Help: "Timestamp of the last growth of the membership filter",
+		}),
+		bootstrapKeys: metrics.Gauge(prometheus.GaugeOpts{
+			Name: "bloom_filter_bootstrap_keys",
+			Help: "Keys loaded into the membership filter by the startup bootstrap",
+		}),
+		ready: metrics.Gauge(prometheus.GaugeOpts{
+			Name: "bloom_filter_ready",
+			Help: "1 once the membership filter can exclude keys, 0 while it is bootstrapped or after a failed bootstrap",
+		}),
+		stages: metrics.Gauge(prometheus.GaugeOpts{
+			Name: "bloom_filter_stages",
+			Help: "Number of chained filters, 1 for fixed-size filters",
+		}),
+	}
+}
+
+// update sets the gauges describing the filter
+func (m *filterMetrics) update(stats FilterStats) {
+	m.capacity.Set(float64(stats.Capacity))
+	m.count.Set(float64(stats.Count))
+	m.hashFunctions.Set(float64(stats.HashFunctions))
+	m.falsePositive.Set(stats.FalsePositiveRate)
+	m.loadFactor.Set(stats.LoadFactor)
+	m.stages.Set(float64(stats.Stages))
+	if !stats.LastAdjustment.IsZero() {
+		m.lastAdjustment.Set(float64(stats.LastAdjustment.Unix()))
+	}
+}
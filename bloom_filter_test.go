@@ -1,6 +1,8 @@
 package multi_tier_caching
 
 import (
+	"fmt"
+	"math/rand/v2"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -20,3 +22,41 @@ func TestBloomFilter(t *testing.T) {
 	// Check for another key that is not there
 	assert.False(t, filter.Exists("other_key"), "The other key should not exist in the filter")
 }
+
+func TestBloomFilter_NoFalseNegatives(t *testing.T) {
+	for seed := range uint64(5) {
+		rng := rand.New(rand.NewPCG(seed, seed))
+		filter := NewBloomFilter(1024, 7, false, nil)
+
+		// Keys arrive in batches of random size and the filter grows far beyond its first stage.
+		// Each batch is checked, every key added so far whenever a stage was chained.
+		var added []string
+		for stages := 1; len(added) < 20000; {
+			batch := len(added)
+			for range rng.IntN(500) + 1 {
+				key := fmt.Sprintf("key-%d", rng.Uint64())
+				filter.Add(key)
+				added = append(added, key)
+			}
+			if len(filter.stages) != stages {
+				stages, batch = len(filter.stages), 0
+			}
+			for _, key := range added[batch:] {
+				if !filter.Exists(key) {
+					t.Fatalf("seed %d: key %s added before %d stages is reported absent", seed, key, len(filter.stages))
+				}
+			}
+		}
+		assert.Greater(t, len(filter.stages), 5, "the filter chained new stages")
+
+		// The compound false positive rate stays bounded while the filter grows
+		falsePositives := 0
+		for i := range 10000 {
+			if filter.Exists(fmt.Sprintf("absent-%d", i)) {
+				falsePositives++
+			}
+		}
+		firstRate := filter.stages[0].fpRate
+		assert.Less(t, float64(falsePositives)/10000, firstRate/(1-bloomTightening), "seed %d", seed)
+	}
+}
@@ -0,0 +1,303 @@
+package multi_tier_caching
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/arturmon/multi-tier-caching/storage"
+	"github.com/bits-and-blooms/bloom/v3"
+)
+
+const (
+	snapshotMagic   = "MTCBLOOM"
+	snapshotVersion = 1
+	// snapshotComplete marks a snapshot written on Close, it holds every key stored until then
+	snapshotComplete = 1 << 0
+	// snapshotCatchUpSlack widens the scan after a complete snapshot to the rows stored shortly
+	// before it: clocks of instances differ and queued writes keep the time of their Set
+	snapshotCatchUpSlack = time.Minute
+)
+
+var (
+	// ErrSnapshotFormat is returned by Restore for data that is not a snapshot of a supported version
+	ErrSnapshotFormat = errors.New("invalid bloom filter snapshot")
+	// ErrSnapshotMismatch is returned by Restore for a snapshot of a filter of another size or
+	// number of hash functions
+	ErrSnapshotMismatch = errors.New("bloom filter snapshot does not match the filter")
+)
+
+// snapshotHeader precedes the stages of a BloomFilter snapshot
+type snapshotHeader struct {
+	Magic         [8]byte
+	Version       uint16
+	Flags         uint16
+	Created       int64  // Unix nanoseconds
+	Size          uint64 // Bits of the first stage
+	HashFunctions uint32 // Hash functions of the first stage
+	Stages        uint32
+}
+
+// snapshotStage precedes the bits of every stage, which use the bits-and-blooms binary encoding
+type snapshotStage struct {
+	Capacity uint64
+	Count    uint64
+	FPRate   float64
+}
+
+// snapshotFilter — membership filters the cache can save to a file
+type snapshotFilter interface {
+	snapshot(w io.Writer, complete bool) error
+	restore(r io.Reader) (snapshotHeader, error)
+}
+
+// Snapshot writes the filter to w: a versioned header recording the size, the hash functions
+// and the time of the snapshot, followed by every stage
+func (b *BloomFilter) Snapshot(w io.Writer) error {
+	return b.snapshot(w, false)
+}
+
+// Restore replaces the filter with a snapshot written by Snapshot of a filter created with the
+// same size and hash functions
+func (b *BloomFilter) Restore(r io.Reader) error {
+	_, err := b.restore(r)
+	return err
+}
+
+func (b *BloomFilter) snapshot(w io.Writer, complete bool) error {
+	// Copy the stages so Add is not blocked while they are written
+	b.mu.Lock()
+	stages := make([]bloomStage, len(b.stages))
+	for i, stage := range b.stages {
+		stages[i] = *stage
+		stages[i].filter = stage.filter.Copy()
+	}
+	b.mu.Unlock()
+
+	header := snapshotHeader{
+		Version:       snapshotVersion,
+		Created:       time.Now().UnixNano(),
+		Size:          uint64(stages[0].filter.Cap()),
+		HashFunctions: uint32(stages[0].filter.K()),
+		Stages:        uint32(len(stages)),
+	}
+	copy(header.Magic[:], snapshotMagic)
+	if complete {
+		header.Flags |= snapshotComplete
+	}
+	if err := binary.Write(w, binary.LittleEndian, header); err != nil {
+		return fmt.Errorf("write snapshot header: %w", err)
+	}
+	for i, stage := range stages {
+		err := binary.Write(w, binary.LittleEndian, snapshotStage{
+			Capacity: uint64(stage.capacity),
+			Count:    uint64(stage.count),
+			FPRate:   stage.fpRate,
+		})
+		if err == nil {
+			_, err = stage.filter.WriteTo(w)
+		}
+		if err != nil {
+			return fmt.Errorf("write snapshot stage %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func (b *BloomFilter) restore(r io.Reader) (snapshotHeader, error) {
+	var header snapshotHeader
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+		return header, fmt.Errorf("%w: read header: %v", ErrSnapshotFormat, err)
+	}
+	if string(header.Magic[:]) != snapshotMagic || header.Version != snapshotVersion {
+		return header, fmt.Errorf("%w: version %d", ErrSnapshotFormat, header.Version)
+	}
+	if header.Stages == 0 {
+		return header, fmt.Errorf("%w: no stages", ErrSnapshotFormat)
+	}
+	b.mu.Lock()
+	size, hashes := b.stages[0].filter.Cap(), b.stages[0].filter.K()
+	b.mu.Unlock()
+	if header.Size != uint64(size) || header.HashFunctions != uint32(hashes) {
+		return header, fmt.Errorf("%w: %d bits and %d hash functions, the filter has %d and %d",
+			ErrSnapshotMismatch, header.Size, header.HashFunctions, size, hashes)
+	}
+
+	stages := make([]*bloomStage, 0, header.Stages)
+	for i := range header.Stages {
+		var meta snapshotStage
+		filter := &bloom.BloomFilter{}
+		err := binary.Read(r, binary.LittleEndian, &meta)
+		if err == nil {
+			_, err = filter.ReadFrom(r)
+		}
+		if err != nil {
+			return header, fmt.Errorf("%w: read stage %d: %v", ErrSnapshotFormat, i, err)
+		}
+		if meta.Capacity == 0 {
+			return header, fmt.Errorf("%w: stage %d is empty", ErrSnapshotFormat, i)
+		}
+		stages = append(stages, &bloomStage{
+			filter:   filter,
+			capacity: uint(meta.Capacity),
+			count:    uint(meta.Count),
+			fpRate:   meta.FPRate,
+		})
+	}
+	if uint64(stages[0].filter.Cap()) != header.Size || uint32(stages[0].filter.K()) != header.HashFunctions {
+		return header, fmt.Errorf("%w: header does not match the first stage", ErrSnapshotFormat)
+	}
+
+	b.mu.Lock()
+	b.stages = stages
+	b.lastAdjustment = time.Now()
+	b.mu.Unlock()
+	if b.debug {
+		log.Printf("[BLOOM] Restored %d stages from a snapshot of %s",
+			len(stages), time.Unix(0, header.Created).Format(time.RFC3339))
+	}
+	return header, nil
+}
+
+// filterSnapshots saves the membership filter to a file every interval and on Close
+type filterSnapshots struct {
+	path       string
+	interval   time.Duration // 0 saves only on Close
+	filter     snapshotFilter
+	restored   bool // A complete snapshot was loaded on startup
+	debug      bool
+	background storage.Background
+}
+
+// Start runs the periodic snapshots, NewMultiTierCache calls it
+func (s *filterSnapshots) Start(ctx context.Context) {
+	if s.interval > 0 {
+		s.background.Start(ctx, s.snapshotter)
+	}
+}
+
+// Stop stops the periodic snapshots
+func (s *filterSnapshots) Stop() {
+	s.background.Stop()
+}
+
+func (s *filterSnapshots) snapshotter(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.save(false); err != nil {
+				log.Printf("[BLOOM] %v", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// load restores the filter from the file, it reports whether the file exists
+func (s *filterSnapshots) load() (snapshotHeader, bool, error) {
+	file, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return snapshotHeader{}, false, nil
+	} else if err != nil {
+		return snapshotHeader{}, false, fmt.Errorf("open bloom filter snapshot: %w", err)
+	}
+	defer file.Close()
+	header, err := s.filter.restore(bufio.NewReader(file))
+	if err != nil {
+		return header, true, fmt.Errorf("restore bloom filter snapshot %s: %w", s.path, err)
+	}
+	return header, true, nil
+}
+
+// save replaces the file with a snapshot of the filter
+func (s *filterSnapshots) save(complete bool) error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return fmt.Errorf("create bloom filter snapshot directory: %w", err)
+	}
+	tmpPath := s.path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return fmt.Errorf("create bloom filter snapshot: %w", err)
+	}
+	writer := bufio.NewWriter(tmp)
+	err = s.filter.snapshot(writer, complete)
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("write bloom filter snapshot: %w", err)
+	}
+	if err = os.Rename(tmpPath, s.path); err != nil {
+		return fmt.Errorf("replace bloom filter snapshot: %w", err)
+	}
+	if s.debug {
+		log.Printf("[BLOOM] Saved snapshot to %s, complete: %v", s.path, complete)
+	}
+	return nil
+}
+
+// keysSince scans the keys stored after since, so the bootstrap catches up a restored snapshot
+type keysSince struct {
+	db    IncrementalKeyScanner
+	since time.Time
+}
+
+func (s keysSince) ScanKeys(ctx context.Context, fn func(key string) error) error {
+	return s.db.ScanKeysSince(ctx, s.since, fn)
+}
+
+// restoreFilterSnapshot loads the snapshot of the filter and returns its time when it holds
+// every key stored before the last Close, so the bootstrap only scans the keys stored since.
+// It returns the zero time otherwise. The file is rewritten as incomplete right away: after a
+// crash it would miss the keys stored since.
+func (c *MultiTierCache) restoreFilterSnapshot() time.Time {
+	header, exists, err := c.snapshots.load()
+	if err != nil {
+		log.Printf("[BLOOM] %v", err)
+		return time.Time{}
+	}
+	if !exists {
+		return time.Time{}
+	}
+	if err = c.snapshots.save(false); err != nil {
+		log.Printf("[BLOOM] %v", err)
+	}
+	c.snapshots.restored = header.Flags&snapshotComplete != 0
+	created := time.Unix(0, header.Created)
+	log.Printf("[BLOOM] Restored the filter from a snapshot of %s, complete: %v",
+		created.Format(time.RFC3339), c.snapshots.restored)
+	if !c.snapshots.restored {
+		return time.Time{}
+	}
+	return created
+}
+
+// saveFilterSnapshot writes the final snapshot on shutdown. It is complete only when the filter
+// held every key of the database since startup: bootstrapped, or restored from a complete
+// snapshot without a bootstrap.
+func (c *MultiTierCache) saveFilterSnapshot() {
+	c.snapshots.Stop()
+	progress := c.filter.BootstrapProgress()
+	bootstrapped := !progress.Finished.IsZero() && progress.Err == nil
+	complete := bootstrapped || (c.snapshots.restored && progress.Started.IsZero())
+	if err := c.snapshots.save(complete); err != nil {
+		log.Printf("[BLOOM] %v", err)
+	}
+}
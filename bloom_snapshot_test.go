@@ -0,0 +1,107 @@
+package multi_tier_caching
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBloomFilter_SnapshotRestore(t *testing.T) {
+	filter := NewBloomFilter(1024, 7, false, nil)
+	for i := range 1000 {
+		filter.Add(fmt.Sprintf("key-%d", i))
+	}
+	require.Greater(t, len(filter.stages), 1)
+
+	var buf bytes.Buffer
+	require.NoError(t, filter.Snapshot(&buf))
+	restored := NewBloomFilter(1024, 7, false, nil)
+	require.NoError(t, restored.Restore(bytes.NewReader(buf.Bytes())))
+	for i := range 1000 {
+		assert.True(t, restored.Exists(fmt.Sprintf("key-%d", i)))
+	}
+	assert.Equal(t, filter.Stats().Count, restored.Stats().Count)
+	assert.Equal(t, filter.Stats().Capacity, restored.Stats().Capacity)
+
+	// Growth continues from the restored stages
+	restored.Add("new-key")
+	assert.True(t, restored.Exists("new-key"))
+
+	corrupt := append([]byte{}, buf.Bytes()...)
+	corrupt[8] = 99 // Version
+	assert.ErrorIs(t, restored.Restore(bytes.NewReader(corrupt)), ErrSnapshotFormat)
+	assert.ErrorIs(t, restored.Restore(bytes.NewReader(buf.Bytes()[:40])), ErrSnapshotFormat)
+	assert.True(t, restored.Exists("key-1"), "a failed restore keeps the filter")
+
+	assert.ErrorIs(t, NewBloomFilter(1024, 5, false, nil).Restore(bytes.NewReader(buf.Bytes())), ErrSnapshotMismatch)
+	other := NewBloomFilter(2048, 7, false, nil)
+	assert.ErrorIs(t, other.Restore(bytes.NewReader(buf.Bytes())), ErrSnapshotMismatch)
+	assert.False(t, other.Exists("key-1"), "a snapshot of another size is not restored")
+}
+
+func TestMultiTierCache_BloomSnapshot(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "bloom.snapshot")
+	db := &scanTestStore{
+		batchTestStore: newBatchTestStore("db", map[string]string{"key1": "value1"}),
+		release:        make(chan struct{}),
+	}
+	close(db.release)
+	newCache := func() *MultiTierCache {
+		return NewMultiTierCache(ctx, MultiTierCacheConfig{
+			Layers:            []LayerInfo{NewLayerInfo(newBatchTestStore("memory", nil))},
+			DB:                db,
+			Thresholds:        []int{0},
+			BloomSize:         1000,
+			BloomHashes:       3,
+			BloomBootstrap:    true,
+			BloomSnapshotPath: path,
+			WritePolicy:       WriteThrough,
+			Registerer:        prometheus.NewRegistry(),
+		})
+	}
+
+	// The first start scans the database, Close saves a complete snapshot
+	cache := newCache()
+	require.NoError(t, cache.WaitReady(ctx))
+	require.NoError(t, cache.Set(ctx, "key2", "value2"))
+	cache.Close()
+
+	// Another instance stores a key while this one is stopped
+	db.mu.Lock()
+	db.data["key3"] = "value3"
+	db.storedAt = map[string]time.Time{"key3": time.Now()}
+	db.mu.Unlock()
+
+	// The restart only scans the keys stored since the snapshot, excluding no key until then
+	db.release = make(chan struct{})
+	cache = newCache()
+	assert.True(t, cache.BootstrapProgress().Running())
+	assert.True(t, cache.filter.Exists("absent"), "no key is excluded until the catch-up scan finished")
+	close(db.release)
+	require.NoError(t, cache.WaitReady(ctx))
+	assert.Equal(t, int64(1), cache.BootstrapProgress().Keys, "the scan reads the keys stored since the snapshot")
+	require.Len(t, db.since, 1)
+	assert.WithinDuration(t, time.Now().Add(-snapshotCatchUpSlack), db.since[0], 10*time.Second)
+	assert.True(t, cache.filter.Exists("key1"))
+	assert.True(t, cache.filter.Exists("key2"))
+	assert.True(t, cache.filter.Exists("key3"), "keys stored by other instances are caught up")
+	assert.False(t, cache.filter.Exists("absent"))
+	db.release = make(chan struct{})
+
+	// The loaded snapshot no longer counts as complete: a crash now must not skip the next scan
+	crashed := newCache()
+	assert.True(t, crashed.BootstrapProgress().Running())
+	close(db.release)
+	require.NoError(t, crashed.WaitReady(ctx))
+	assert.Len(t, db.since, 1, "an incomplete snapshot is followed by the whole scan")
+	crashed.Close()
+	cache.Close()
+}
@@ -6,7 +6,12 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"path/filepath"
+	"sync"
 	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sync/singleflight"
 )
 
 // ErrCacheMiss Add this error definition
@@ -15,27 +20,171 @@ var ErrCacheMiss = errors.New("cache miss")
 type MultiTierCache struct {
 	layers         []LayerInfo // Cache layers sorted from hot to cold
 	db             Database
-	bloomFilter    *BloomFilter
+	keepOpen       bool // KeepComponentsOpen
+	filter         *membership
+	snapshots      *filterSnapshots // nil without BloomSnapshotPath
 	writeQueue     *WriteQueue
 	analytics      *CacheAnalytics
 	migration      *MigrationManager
 	ttlManager     *TTLManager
 	freqThresholds []int // Request rate thresholds for transition between layers
-	debug          bool  //
+	loadGroup      singleflight.Group
+	backfillPolicy BackfillPolicy  // How lower-tier hits are copied into hotter layers
+	backfillTTLs   []time.Duration // Optional per-layer TTL caps for backfilled values
+	softTTLRatio   float64         // Soft TTL as a fraction of the hard TTL, 0 disables stale-while-revalidate
+	loader         func(ctx context.Context, key string) (string, time.Duration, error)
+	refreshing     sync.Map       // Keys with a background revalidation in progress
+	backfilling    sync.Map       // Keys with an async backfill in progress
+	tasks          sync.WaitGroup // Async backfills, revalidations and the Bloom bootstrap, awaited by Shutdown
+	tasksMu        sync.Mutex     // Guards tasksClosed and orders spawn before the Wait of Shutdown
+	tasksClosed    bool           // Set by Shutdown, spawn starts no more tasks
+	stopBootstrap  context.CancelFunc
+	negativeTTL    time.Duration // TTL of tombstones for keys absent from the database, 0 disables them
+	writePolicy    WritePolicy   // Default policy of Set
+	deleteFromDB   bool          // Delete and Invalidate also remove keys from the database
+	debug          bool          //
 }
 type MultiTierCacheConfig struct {
-	Layers      []LayerInfo // Cache layers sorted from hot to cold
-	DB          Database
+	Layers []LayerInfo // Cache layers sorted from hot to cold
+	DB     Database
+	// Thresholds are the minimum request rates of a key for each layer (same order as Layers), in
+	// requests per FrequencyWindow. The rate decays with FrequencyHalfLife, so a key requested
+	// steadily settles at its real rate and cools down once it is no longer requested. Thresholds
+	// used to count every request since the key was first seen: a threshold of 10 meant 10 requests
+	// in total, it now means 10 requests per minute. Lower the thresholds that relied on the lifetime
+	// count, or set FrequencyWindow to the period in which keys should reach them.
 	Thresholds  []int
 	BloomSize   uint
 	BloomHashes uint
-	Debug       bool
+	// Filter tells which keys may be stored in the database, nil uses a BloomFilter of BloomSize
+	// bits and BloomHashes hash functions. A CountingBloomFilter forgets deleted and expired keys,
+	// the cache then keeps the set of keys it added so that it removes each of them once. The set
+	// holds up to the key capacity of the filter (size*ln2/hashes keys, the key strings plus about
+	// 50 bytes each), keys past it are never removed.
+	Filter MembershipFilter
+	// BloomBootstrap loads the keys already stored in the database into the Bloom filter on startup.
+	// The database must implement KeyScanner. Until the load finished the filter excludes no key,
+	// WaitReady blocks until then.
+	BloomBootstrap bool
+	// BloomSnapshotPath enables warm restarts: the filter is restored from this file on startup and
+	// written to it every BloomSnapshotInterval and on Close. After a snapshot written by Close the
+	// BloomBootstrap scan only reads the rows stored since, when the database implements
+	// IncrementalKeyScanner; after an older one the whole scan runs. The filter excludes no key
+	// until the scan finished. A snapshot of another BloomSize or BloomHashes is ignored. Only
+	// BloomFilter supports snapshots.
+	BloomSnapshotPath string
+	// BloomSnapshotInterval is the period of the snapshot writes, 0 writes only on Close.
+	BloomSnapshotInterval time.Duration
+	// DeleteFromDB makes Delete and Invalidate remove keys from the database as well.
+	// The database must implement DatabaseDeleter.
+	DeleteFromDB bool
+	// Backfill copies values found in a colder layer into the hotter ones, see BackfillPolicy.
+	Backfill BackfillPolicy
+	// BackfillTTLs optionally caps the TTL of backfilled values per layer (same order as Layers).
+	BackfillTTLs []time.Duration
+	// SoftTTLRatio enables stale-while-revalidate: after SoftTTLRatio*TTL the cached value is
+	// still served while a single background refresh runs, Get blocks only after the hard TTL.
+	// Must be in (0, 1), 0 disables the mode.
+	SoftTTLRatio float64
+	// Loader is the source used to refresh stale keys, the database is used when it is nil.
+	Loader func(ctx context.Context, key string) (string, time.Duration, error)
+	// NegativeTTL enables negative caching: keys the database reports as absent get a
+	// tombstone with this TTL in every layer not backed by the database, so repeated misses
+	// skip the database. Keys with a pending write-behind task get no tombstone.
+	NegativeTTL time.Duration
+	// WritePolicy is the default policy of Set, write-behind when not set. See WritePolicy.
+	WritePolicy WritePolicy
+	// WALDir enables the durable write-behind queue: pending writes are kept in a write-ahead
+	// log in this directory and replayed on startup. See NewMultiTierCache and OpenMultiTierCache
+	// for a log that cannot be opened.
+	WALDir string
+	// WriteWorkers is the number of workers persisting queued writes concurrently, 1 when 0.
+	// Every key is served by one worker, so writes of a key keep their order.
+	WriteWorkers int
+	// WriteBatchSize is the maximum number of queued writes persisted at once, DefaultWriteBatchSize when 0.
+	WriteBatchSize int
+	// WriteMaxLinger is how long a partial batch of queued writes waits for more, DefaultWriteMaxLinger when 0.
+	WriteMaxLinger time.Duration
+	// WriteQueueCapacity bounds the number of pending queued writes, 0 leaves the queue unbounded.
+	// It is split between the WriteWorkers, a write overflows once its worker's share is full.
+	WriteQueueCapacity int
+	// WriteOverflow is what a write does when the queue is full, see OverflowPolicy.
+	WriteOverflow OverflowPolicy
+	// WriteRetry controls retries of failed queued writes, DefaultRetryPolicy for zero fields.
+	WriteRetry RetryPolicy
+	// DeadLetterSink receives queued writes that failed every retry. When nil it is a JSONL file
+	// next to the write-ahead log with WALDir, in-memory otherwise.
+	DeadLetterSink DeadLetterSink
+	// FrequencyHalfLife is the time after which a request counts half as much in the key
+	// frequencies used for layer selection, TTLs and migrations, DefaultFrequencyHalfLife when 0.
+	FrequencyHalfLife time.Duration
+	// FrequencyWindow is the unit key frequencies are expressed in, so thresholds are requests
+	// per window. It only scales the rate, how fast requests are forgotten is FrequencyHalfLife.
+	// DefaultFrequencyWindow (a minute) when 0.
+	FrequencyWindow time.Duration
+	// Registerer receives the Prometheus collectors of the cache, prometheus.DefaultRegisterer when nil.
+	// Caches sharing a registry need distinct MetricsNamespace or MetricsLabels, registering the same
+	// metrics twice fails OpenMultiTierCache and panics in NewMultiTierCache.
+	Registerer prometheus.Registerer
+	// MetricsNamespace prefixes every metric name of the cache.
+	MetricsNamespace string
+	// MetricsLabels are constant labels added to every metric, e.g. {"cache_name": "users"},
+	// they keep the metrics of caches sharing a registry apart.
+	MetricsLabels prometheus.Labels
+	// KeepComponentsOpen leaves the layers and the database running on Close, for callers that
+	// share them between caches or close them on their own. By default Close stops the ones
+	// implementing Lifecycle and closes the database when it is an io.Closer.
+	KeepComponentsOpen bool
+	Debug              bool
 }
 
+// NewMultiTierCache builds the cache and starts its background work. It panics on an invalid
+// configuration or metrics already registered. A write-ahead log (WALDir) that cannot be opened
+// is logged and the queue runs without it, use OpenMultiTierCache to get the error instead.
 func NewMultiTierCache(ctx context.Context, config MultiTierCacheConfig) *MultiTierCache {
+	cache, err := newMultiTierCache(ctx, config, false)
+	if err != nil {
+		panic(err.Error())
+	}
+	return cache
+}
+
+// OpenMultiTierCache is NewMultiTierCache returning an error for an invalid configuration, a
+// write-ahead log that cannot be opened or metrics already registered by another cache
+// (prometheus.AlreadyRegisteredError)
+func OpenMultiTierCache(ctx context.Context, config MultiTierCacheConfig) (*MultiTierCache, error) {
+	return newMultiTierCache(ctx, config, true)
+}
+
+func newMultiTierCache(ctx context.Context, config MultiTierCacheConfig, requireWAL bool) (*MultiTierCache, error) {
 	if len(config.Thresholds) != len(config.Layers) {
-		panic("The number of thresholds (thresholds) must be equal to the number of cache layers (layers)")
+		return nil, errors.New("The number of thresholds (thresholds) must be equal to the number of cache layers (layers)")
 	}
+	if config.SoftTTLRatio < 0 || config.SoftTTLRatio >= 1 {
+		return nil, errors.New("The soft TTL ratio (SoftTTLRatio) must be in [0, 1)")
+	}
+	var queueOpts []WriteQueueOption
+	if config.WALDir != "" {
+		wal, err := OpenWAL(config.WALDir, config.Debug)
+		switch {
+		case err == nil:
+			queueOpts = append(queueOpts, WithWAL(wal))
+			if config.DeadLetterSink == nil {
+				// Dead letters stay as durable as the queued writes
+				sink, err := NewFileDeadLetterSink(filepath.Join(config.WALDir, deadLetterFileName))
+				if err != nil {
+					log.Printf("[CACHE] Dead letters are kept in memory: %v", err)
+				} else {
+					config.DeadLetterSink = sink
+				}
+			}
+		case requireWAL:
+			return nil, fmt.Errorf("open the write-ahead log: %w", err)
+		default:
+			log.Printf("[CACHE] Failed to open the write-ahead log, queued writes are not durable: %v", err)
+		}
+	}
+
 	var layersInfo []LayerInfo
 	for _, layer := range config.Layers {
 		layersInfo = append(layersInfo, LayerInfo{
@@ -43,10 +192,22 @@ func NewMultiTierCache(ctx context.Context, config MultiTierCacheConfig) *MultiT
 			Name:  layer.Layer.String(),
 		})
 	}
-	ttlManager := NewTTLManager(config.Debug)
-	analytics := NewCacheAnalytics()
+	metrics, registration := MetricsConfig{
+		Registerer:  config.Registerer,
+		Namespace:   config.MetricsNamespace,
+		ConstLabels: config.MetricsLabels,
+	}.Track()
+	ttlManager := NewTTLManager(config.Debug, WithTTLMetrics(metrics))
+	analytics := NewCacheAnalytics(
+		WithAnalyticsMetrics(metrics),
+		WithHalfLife(config.FrequencyHalfLife),
+		WithFrequencyWindow(config.FrequencyWindow),
+	)
 
-	bloomFilter := NewBloomFilter(config.BloomSize, config.BloomHashes, config.Debug, analytics)
+	filter := config.Filter
+	if filter == nil {
+		filter = NewBloomFilter(config.BloomSize, config.BloomHashes, config.Debug, analytics)
+	}
 
 	migrationMgr := NewMigrationManager(
 		layersInfo,
@@ -57,22 +218,70 @@ func NewMultiTierCache(ctx context.Context, config MultiTierCacheConfig) *MultiT
 		config.Debug,
 	)
 
+	scanner, _ := config.DB.(KeyScanner)
 	cache := &MultiTierCache{
-		layers:      layersInfo,
-		db:          config.DB,
-		bloomFilter: bloomFilter,
-		writeQueue: NewWriteQueue(func(task WriteTask) {
-			_ = config.DB.Set(ctx, task.Key, task.Value, task.TTL)
-		}, config.Debug),
-		analytics:  analytics,
-		migration:  migrationMgr,
-		ttlManager: ttlManager,
-		debug:      config.Debug,
+		layers:         layersInfo,
+		db:             config.DB,
+		keepOpen:       config.KeepComponentsOpen,
+		filter:         newMembership(filter, scanner, config.Debug, metrics),
+		analytics:      analytics,
+		migration:      migrationMgr,
+		ttlManager:     ttlManager,
+		freqThresholds: config.Thresholds,
+		backfillPolicy: config.Backfill,
+		backfillTTLs:   config.BackfillTTLs,
+		softTTLRatio:   config.SoftTTLRatio,
+		loader:         config.Loader,
+		negativeTTL:    config.NegativeTTL,
+		writePolicy:    config.WritePolicy,
+		deleteFromDB:   config.DeleteFromDB,
+		debug:          config.Debug,
+	}
+	queueOpts = append(queueOpts,
+		WithWorkers(config.WriteWorkers),
+		WithBatchSize(config.WriteBatchSize),
+		WithMaxLinger(config.WriteMaxLinger),
+		WithCapacity(config.WriteQueueCapacity, config.WriteOverflow),
+		WithRetryPolicy(config.WriteRetry),
+		WithDeadLetterSink(config.DeadLetterSink),
+		WithSuperseded(cache.supersededLetter),
+		WithMetrics(metrics),
+	)
+	cache.writeQueue = NewWriteQueue(func(ctx context.Context, tasks []WriteTask) error {
+		err := cache.persistBatch(ctx, tasks)
+		cache.filterWritten(tasks)
+		return err
+	}, config.Debug, queueOpts...)
+	if err := registration.Err(); err != nil {
+		// Every collector is registered by now, the snapshots, bootstrap and migrations did not start
+		cache.keepOpen = true
+		cache.Close()
+		registration.Undo()
+		return nil, err
+	}
+
+	var restored time.Time // Time of a complete snapshot, the bootstrap scans the keys stored since
+	if config.BloomSnapshotPath != "" {
+		if snapshotter, ok := filter.(snapshotFilter); ok {
+			cache.snapshots = &filterSnapshots{
+				path:     config.BloomSnapshotPath,
+				interval: config.BloomSnapshotInterval,
+				filter:   snapshotter,
+				debug:    config.Debug,
+			}
+			restored = cache.restoreFilterSnapshot()
+			cache.snapshots.Start(ctx)
+		} else {
+			log.Printf("[BLOOM] Snapshots skipped: the filter does not support them")
+		}
+	}
+	if config.BloomBootstrap {
+		cache.startBloomBootstrap(ctx, restored)
 	}
 
 	// Background process for migrating data between layers
 	migrationMgr.Start(ctx)
-	return cache
+	return cache, nil
 }
 
 func NewLayerInfo(layer CacheLayer) LayerInfo {
@@ -83,37 +292,28 @@ func NewLayerInfo(layer CacheLayer) LayerInfo {
 }
 
 func (c *MultiTierCache) Get(ctx context.Context, key string) (string, error) {
-	// First we look in the hottest layer (the first layer)
-	value, err := c.layers[0].Layer.Get(ctx, key)
-	if err == nil {
-		c.analytics.LogHit(fmt.Sprintf("layer_%s", c.layers[0].Name), key)
-		if c.debug {
-			log.Printf("[CACHE] Found key=%s in hot layer", key)
-		}
+	switch value, status := c.lookupLayers(ctx, key, nil); status {
+	case lookupHit:
 		return value, nil
-	}
-
-	// If not found in the hot layer, continue searching in other layers
-	for i := 1; i < len(c.layers); i++ {
-		value, err = c.layers[i].Layer.Get(ctx, key)
-		if err == nil {
-			c.analytics.LogHit(fmt.Sprintf("layer_%s", c.layers[i].Name), key)
-			if c.debug {
-				log.Printf("[CACHE] Found key=%s in layer=%d (%v)", key, i, c.layers[i].Name)
-			}
-			return value, nil
-		}
+	case lookupNegative:
+		c.analytics.LogMiss()
+		return "", ErrCacheMiss
 	}
 
 	// If not found in the layer and the Bloom filter does not exclude the key
-	if !c.bloomFilter.Exists(key) {
+	if !c.filter.Exists(key) {
 		c.analytics.LogMiss()
 		return "", ErrCacheMiss
 	}
 
 	// If you didn't find it in the cache, go to the database
-	value, err = c.db.Get(ctx, key)
-	if err != nil {
+	value, err := c.db.Get(ctx, key)
+	if isCacheMiss(err) {
+		c.analytics.LogMiss()
+		c.forgetExpired(ctx, key)
+		c.storeNegative(ctx, key)
+		return "", ErrCacheMiss
+	} else if err != nil {
 		c.analytics.LogMiss()
 		return "", err
 	}
@@ -129,16 +329,122 @@ func (c *MultiTierCache) Get(ctx context.Context, key string) (string, error) {
 	}
 
 	// Refresh the cache in the selected layers
-	err = c.initCachePlacement(ctx, key, value, targetLayers)
+	err = c.initCachePlacement(ctx, key, value, targetLayers, 0)
 	if err != nil {
 		return "", err
 	}
 
-	c.bloomFilter.Add(key)
+	c.filter.Add(key)
 	return value, nil
 }
 
+// GetOrLoad reads the key through the cache layers and calls loader when every layer misses.
+// Concurrent callers for the same key share a single loader call, the loaded value
+// is placed into the cache like a database hit. A positive TTL returned by the loader
+// overrides the adaptive TTL.
+func (c *MultiTierCache) GetOrLoad(ctx context.Context, key string, loader func(ctx context.Context) (string, time.Duration, error)) (string, error) {
+	// Tombstones only describe the database, the loader may know the key
+	if value, status := c.lookupLayers(ctx, key, loader); status == lookupHit {
+		return value, nil
+	}
+
+	// The load is shared, cancelling the caller that started it must not fail the other callers
+	loadCtx := context.WithoutCancel(ctx)
+	results := c.loadGroup.DoChan(key, func() (interface{}, error) {
+		// Another caller may have placed the value while we were waiting for the group
+		if value, status := c.lookupLayers(loadCtx, key, loader); status == lookupHit {
+			return value, nil
+		}
+		value, ttl, err := loader(loadCtx)
+		if err != nil {
+			c.analytics.LogMiss()
+			return "", err
+		}
+		c.analytics.LogHit("loader", key)
+
+		freq := c.analytics.GetFrequency(key)
+		if err = c.initCachePlacement(loadCtx, key, value, c.selectTargetLayers(freq), ttl); err != nil {
+			return "", err
+		}
+		return value, nil
+	})
+	var result singleflight.Result
+	select {
+	case result = <-results:
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+	if c.debug && result.Shared {
+		log.Printf("[CACHE] Shared loader result for key=%s", key)
+	}
+	if result.Err != nil {
+		return "", result.Err
+	}
+	return result.Val.(string), nil
+}
+
+// lookupLayers searches the key from the hottest layer to the coldest one.
+// A stale entry is still returned and refreshed in the background with refresh (see revalidate).
+func (c *MultiTierCache) lookupLayers(ctx context.Context, key string, refresh func(ctx context.Context) (string, time.Duration, error)) (string, lookupStatus) {
+	for i, layer := range c.layers {
+		entry, err := c.readLayer(ctx, layer.Layer, key)
+		if err != nil {
+			continue
+		}
+		if entry.Value == negativeEntry {
+			c.analytics.LogNegativeHit()
+			if c.debug {
+				log.Printf("[CACHE] Negative entry for key=%s in layer=%d (%v)", key, i, layer.Name)
+			}
+			return "", lookupNegative
+		}
+		c.analytics.LogHit(fmt.Sprintf("layer_%s", layer.Name), key)
+		if c.debug {
+			log.Printf("[CACHE] Found key=%s in layer=%d (%v)", key, i, layer.Name)
+		}
+		if entry.IsStale(time.Now()) {
+			if c.debug {
+				log.Printf("[CACHE] Serving stale key=%s, soft expiry %v", key, entry.SoftExpiry)
+			}
+			c.revalidate(ctx, key, i, refresh)
+		}
+		c.backfill(ctx, key, entry, i)
+		return entry.Value, lookupHit
+	}
+	return "", lookupMiss
+}
+
+// Set stores the value using the configured WritePolicy
 func (c *MultiTierCache) Set(ctx context.Context, key, value string) error {
+	return c.SetWithPolicy(ctx, key, value, c.writePolicy)
+}
+
+// setWriteBehind queues the database write and then writes the target layers. A write the
+// queue rejects leaves the cache untouched.
+func (c *MultiTierCache) setWriteBehind(ctx context.Context, key, value string) (time.Duration, bool, error) {
+	c.clearNegative(ctx, key)
+	ttlSeconds, freq, ok := c.writePlan(ctx, key)
+	if !ok {
+		return ttlSeconds, false, nil
+	}
+	if err := c.writeQueue.Enqueue(ctx, c.newWriteTask(key, value, ttlSeconds)); err != nil {
+		return ttlSeconds, false, err
+	}
+	c.filter.Add(key)
+	for _, layerInfo := range c.selectTargetLayers(freq) {
+		if err := c.writeLayer(ctx, layerInfo.Layer, key, value, ttlSeconds); err != nil {
+			log.Printf("Error writing to layer: %v", err)
+			c.invalidateLayers(ctx, key)
+			return ttlSeconds, true, fmt.Errorf("%w: %s: %v", ErrLayerWrite, layerInfo.Name, err)
+		}
+	}
+	c.ttlManager.AdjustTTL(key, int64(ttlSeconds/time.Second))
+	return ttlSeconds, true, nil
+}
+
+// writePlan decides whether a write of the key should be applied to the cache layers
+// and returns its adaptive TTL and request frequency
+func (c *MultiTierCache) writePlan(ctx context.Context, key string) (time.Duration, int, bool) {
 	freq := c.analytics.GetFrequency(key) // We get the frequency of requests
 	adaptiveTTL := c.ttlManager.calculateAdaptiveTTL(freq)
 	currentTTL := c.ttlManager.GetTTL(key)
@@ -152,18 +458,58 @@ func (c *MultiTierCache) Set(ctx context.Context, key, value string) error {
 		currentTTL = adaptiveTTL // Forced update
 	}
 	// Set TTL only if it is greater than the current one
-	if int64(adaptiveTTL) > currentTTL {
-		ttlSeconds := time.Duration(adaptiveTTL) * time.Second
-		targetLayers := c.selectTargetLayers(freq)
-		for _, layerInfo := range targetLayers {
-			if err := layerInfo.Layer.Set(ctx, key, value, ttlSeconds); err != nil {
-				log.Printf("Error writing to layer: %v", err)
-				return err
-			}
+	if int64(adaptiveTTL) <= currentTTL {
+		return time.Duration(adaptiveTTL) * time.Second, freq, false
+	}
+	return time.Duration(adaptiveTTL) * time.Second, freq, true
+}
+
+// Delete removes the key from every cache layer and drops all state tracked for it:
+// TTL, request statistics and pending write-behind tasks. A batch already persisting the key is
+// waited for, so it cannot bring the key back. With DeleteFromDB enabled the key is removed from the database too.
+func (c *MultiTierCache) Delete(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	var deleter DatabaseDeleter
+	if c.deleteFromDB {
+		var ok bool
+		if deleter, ok = c.db.(DatabaseDeleter); !ok {
+			return errors.New("database does not support delete")
+		}
+	}
+
+	// Cancel pending writes and wait for the batch being persisted, so the worker cannot
+	// resurrect the key in the database
+	c.writeQueue.Cancel(key)
+	if err := c.writeQueue.awaitKey(ctx, key); err != nil {
+		return fmt.Errorf("delete key=%s: %w", key, err)
+	}
+
+	for _, layer := range c.layers {
+		layer.Layer.Delete(ctx, key)
+	}
+	if deleter != nil {
+		if err := deleter.DeleteKey(ctx, key); err != nil {
+			return fmt.Errorf("database delete key=%s: %w", key, err)
+		}
+		c.filter.Remove(key) // Without DeleteFromDB the database still holds the key
+	}
+
+	c.ttlManager.RemoveTTL(key)
+	c.analytics.Forget(key)
+	if c.debug {
+		log.Printf("[CACHE] Deleted key=%s", key)
+	}
+	return nil
+}
+
+// Invalidate removes several keys, see Delete.
+func (c *MultiTierCache) Invalidate(ctx context.Context, keys ...string) error {
+	for _, key := range keys {
+		if err := c.Delete(ctx, key); err != nil {
+			return fmt.Errorf("invalidate key=%s: %w", key, err)
 		}
-		c.ttlManager.AdjustTTL(key, int64(adaptiveTTL))
-		c.writeQueue.Enqueue(WriteTask{Key: key, Value: value, TTL: ttlSeconds})
-		c.bloomFilter.Add(key)
 	}
 	return nil
 }
@@ -186,11 +532,91 @@ func (c *MultiTierCache) HealthCheck(ctx context.Context) error {
 	return nil
 }
 
-func (c *MultiTierCache) Close() {
+// RequestRate returns the recent request rate of the key in requests per second
+func (c *MultiTierCache) RequestRate(key string) float64 {
+	return c.analytics.Rate(key)
+}
+
+// RequestCount returns the number of requests of the key since the cache was created
+func (c *MultiTierCache) RequestCount(key string) uint64 {
+	return c.analytics.Count(key)
+}
+
+// TopK returns up to n of the hottest keys with their rates and the layer that served them last
+func (c *MultiTierCache) TopK(n int) []KeyStat {
+	return c.analytics.TopK(n)
+}
+
+// Flush blocks until every write queued before the call has reached the database, it returns
+// ErrDeadLettered when some of them failed every attempt
+func (c *MultiTierCache) Flush(ctx context.Context) error {
+	return c.writeQueue.Flush(ctx)
+}
+
+// spawn runs fn in a goroutine awaited by Shutdown, it reports false once Shutdown started
+func (c *MultiTierCache) spawn(fn func()) bool {
+	c.tasksMu.Lock()
+	defer c.tasksMu.Unlock()
+	if c.tasksClosed {
+		return false
+	}
+	c.tasks.Add(1)
+	go func() {
+		defer c.tasks.Done()
+		fn()
+	}()
+	return true
+}
+
+// Shutdown stops every background component the cache started, in order: migrations and async
+// refreshes first, then the write queue is drained until ctx is done, then the Bloom filter is
+// saved (BloomSnapshotPath) and its updates stopped, last the layers and the database are stopped
+// and the database closed, unless KeepComponentsOpen is set. It returns the number of queued
+// writes that were not persisted.
+func (c *MultiTierCache) Shutdown(ctx context.Context) (int, error) {
+	c.migration.Stop()
+	if c.stopBootstrap != nil {
+		c.stopBootstrap()
+	}
+	c.tasksMu.Lock()
+	c.tasksClosed = true
+	c.tasksMu.Unlock()
+	c.tasks.Wait()
+	unpersisted, err := c.writeQueue.Shutdown(ctx)
+	if c.snapshots != nil {
+		c.saveFilterSnapshot()
+	}
+	c.filter.Stop()
+	if !c.keepOpen {
+		c.stopComponents()
+	}
+	return unpersisted, err
+}
+
+// stopComponents stops the layers and the database implementing Lifecycle and closes the database
+func (c *MultiTierCache) stopComponents() {
+	for _, layer := range c.layers {
+		if lifecycle, ok := layer.Layer.(Lifecycle); ok {
+			lifecycle.Stop()
+		}
+	}
+	if lifecycle, ok := c.db.(Lifecycle); ok {
+		lifecycle.Stop()
+	}
 	if closer, ok := c.db.(io.Closer); ok {
-		closer.Close()
+		if err := closer.Close(); err != nil {
+			log.Printf("[CACHE] Error closing the database: %v", err)
+		}
+	}
+}
+
+// Close is Shutdown bounded by DefaultShutdownTimeout
+func (c *MultiTierCache) Close() {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultShutdownTimeout)
+	defer cancel()
+	if _, err := c.Shutdown(ctx); err != nil {
+		log.Printf("[CACHE] Write queue was not drained: %v", err)
 	}
-	c.writeQueue.Stop()
 }
 
 func (c *MultiTierCache) selectTargetLayers(freq int) []LayerInfo {
@@ -206,7 +632,7 @@ func (c *MultiTierCache) selectTargetLayers(freq int) []LayerInfo {
 	return layers
 }
 
-func (c *MultiTierCache) initCachePlacement(ctx context.Context, key, value string, targetLayers []LayerInfo) error {
+func (c *MultiTierCache) initCachePlacement(ctx context.Context, key, value string, targetLayers []LayerInfo, ttl time.Duration) error {
 	// Get the current TTL of the key
 	currentTTL := c.ttlManager.GetTTL(key)
 	// Calculating a new frequency-based adaptive TTL
@@ -220,10 +646,15 @@ func (c *MultiTierCache) initCachePlacement(ctx context.Context, key, value stri
 	}
 
 	ttlSeconds := time.Duration(currentTTL) * time.Second
+	c.clearNegative(ctx, key)
+	// An explicit TTL (e.g. from a loader) wins over the adaptive one
+	if ttl > 0 {
+		ttlSeconds = ttl
+	}
 
 	// Update target layers with current TTL
 	for _, layerInfo := range targetLayers {
-		if err := layerInfo.Layer.Set(ctx, key, value, ttlSeconds); err != nil {
+		if err := c.writeLayer(ctx, layerInfo.Layer, key, value, ttlSeconds); err != nil {
 			log.Printf("[CACHE] Error writing to layer %v: %v", layerInfo.Name, err)
 			return err
 		}
@@ -232,9 +663,12 @@ func (c *MultiTierCache) initCachePlacement(ctx context.Context, key, value stri
 		}
 	}
 
-	c.bloomFilter.Add(key)
-	// Add a task to the queue with the current TTL
-	c.writeQueue.Enqueue(WriteTask{Key: key, Value: value, TTL: ttlSeconds})
+	c.filter.Add(key)
+	// Add a task to the queue with the current TTL. The value is already cached, so a read
+	// neither waits for a full queue nor drops older writes, the task is skipped instead.
+	if err := c.writeQueue.enqueue(ctx, c.newWriteTask(key, value, ttlSeconds), OverflowFail); err != nil {
+		log.Printf("[CACHE] Failed to queue write for key=%s: %v", key, err)
+	}
 	return nil
 }
 
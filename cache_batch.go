@@ -0,0 +1,253 @@
+package multi_tier_caching
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/arturmon/multi-tier-caching/storage"
+)
+
+// MGet reads a batch of keys layer by layer: keys missed by a layer are requested
+// from the next one in a single batch, the rest goes to the database.
+// Keys that were not found anywhere are absent from the result.
+func (c *MultiTierCache) MGet(ctx context.Context, keys []string) (map[string]string, error) {
+	result := make(map[string]string, len(keys))
+	missing := uniqueKeys(keys)
+
+	for i, layer := range c.layers {
+		if len(missing) == 0 {
+			return result, nil
+		}
+		found, err := getLayerBatch(ctx, layer.Layer, missing)
+		if err != nil {
+			log.Printf("[CACHE] Batch read from layer %v failed: %v", layer.Name, err)
+			continue
+		}
+		for key, value := range found {
+			if value == negativeEntry {
+				// Known to be absent, the key must not reach the database
+				c.analytics.LogNegativeHit()
+				c.analytics.LogMiss()
+				continue
+			}
+			result[key] = value
+			c.analytics.LogHit(fmt.Sprintf("layer_%s", layer.Name), key)
+			c.backfill(ctx, key, Entry{Value: value}, i) // Batch reads carry no metadata
+		}
+		if c.debug {
+			log.Printf("[CACHE] Batch found %d of %d keys in layer=%d (%v)", len(found), len(missing), i, layer.Name)
+		}
+		missing = withoutKeys(missing, found)
+	}
+
+	// Only keys that the Bloom filter does not exclude go to the database
+	candidates := missing[:0]
+	for _, key := range missing {
+		if c.filter.Exists(key) {
+			candidates = append(candidates, key)
+		} else {
+			c.analytics.LogMiss()
+		}
+	}
+	if len(candidates) == 0 {
+		return result, nil
+	}
+
+	found, err := c.getDatabaseBatch(ctx, candidates)
+	if err != nil {
+		return result, err
+	}
+	for _, key := range candidates {
+		value, ok := found[key]
+		if !ok {
+			c.analytics.LogMiss()
+			c.forgetExpired(ctx, key)
+			c.storeNegative(ctx, key)
+			continue
+		}
+		result[key] = value
+		c.analytics.LogHit("database", key)
+
+		freq := c.analytics.GetFrequency(key)
+		if err = c.initCachePlacement(ctx, key, value, c.selectTargetLayers(freq), 0); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// MSet writes a batch of keys. Keys sharing a layer and a TTL are written to that layer in one batch.
+// Keys the write queue rejects are left out of the cache, like with Set.
+// Synchronous write policies persist every key on its own, see SetWithPolicy.
+func (c *MultiTierCache) MSet(ctx context.Context, items map[string]string) error {
+	if c.writePolicy != WriteBehind {
+		for key, value := range items {
+			if err := c.SetWithPolicy(ctx, key, value, c.writePolicy); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	type batchGroup struct {
+		layer int
+		ttl   time.Duration
+	}
+	groups := make(map[batchGroup]map[string]string)
+	planned := make(map[string]time.Duration, len(items))
+
+	var errs []error
+	for key := range items {
+		c.clearNegative(ctx, key)
+		ttl, freq, ok := c.writePlan(ctx, key)
+		if !ok {
+			continue
+		}
+		if err := c.writeQueue.Enqueue(ctx, c.newWriteTask(key, items[key], ttl)); err != nil {
+			errs = append(errs, fmt.Errorf("queue key=%s: %w", key, err))
+			continue
+		}
+		c.filter.Add(key)
+		planned[key] = ttl
+		for i, threshold := range c.freqThresholds {
+			if freq < threshold {
+				continue
+			}
+			group := batchGroup{layer: i, ttl: ttl}
+			if groups[group] == nil {
+				groups[group] = make(map[string]string)
+			}
+			groups[group][key] = items[key]
+		}
+	}
+
+	for group, batch := range groups {
+		layer := c.layers[group.layer]
+		if err := c.setLayerBatch(ctx, layer.Layer, batch, group.ttl); err != nil {
+			log.Printf("[CACHE] Error writing batch to layer %v: %v", layer.Name, err)
+			for key := range batch {
+				c.invalidateLayers(ctx, key)
+			}
+			return fmt.Errorf("%w: %s: %v", ErrLayerWrite, layer.Name, err)
+		}
+		if c.debug {
+			log.Printf("[CACHE] Batch recorded %d keys in %v, TTL=%v", len(batch), layer.Name, group.ttl)
+		}
+	}
+
+	for key, ttl := range planned {
+		c.ttlManager.AdjustTTL(key, int64(ttl/time.Second))
+	}
+	return errors.Join(errs...)
+}
+
+func (c *MultiTierCache) getDatabaseBatch(ctx context.Context, keys []string) (map[string]string, error) {
+	if batch, ok := c.db.(BatchDatabase); ok {
+		return batch.MGet(ctx, keys)
+	}
+	result := make(map[string]string, len(keys))
+	for _, key := range keys {
+		value, err := c.db.Get(ctx, key)
+		if isCacheMiss(err) {
+			continue
+		} else if err != nil {
+			return result, err
+		}
+		result[key] = value
+	}
+	return result, nil
+}
+
+// getLayerBatch uses the layer batch API when available and falls back to sequential reads
+func getLayerBatch(ctx context.Context, layer CacheLayer, keys []string) (map[string]string, error) {
+	if batch, ok := layer.(BatchCacheLayer); ok {
+		return batch.MGet(ctx, keys)
+	}
+	result := make(map[string]string, len(keys))
+	for _, key := range keys {
+		if value, err := layer.Get(ctx, key); err == nil {
+			result[key] = value
+		}
+	}
+	return result, nil
+}
+
+// setLayerBatch uses the layer batch API when available and falls back to sequential writes.
+// Stale-while-revalidate entries need their metadata, so they are always written one by one.
+func (c *MultiTierCache) setLayerBatch(ctx context.Context, layer CacheLayer, items map[string]string, ttl time.Duration) error {
+	if batch, ok := layer.(BatchCacheLayer); ok && !c.staleWhileRevalidate() {
+		return batch.MSet(ctx, items, ttl)
+	}
+	for key, value := range items {
+		if err := c.writeLayer(ctx, layer, key, value, ttl); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// persistBatch writes a batch of write-behind tasks to the database. A BatchWriter gets the whole
+// batch, otherwise tasks without entry metadata are grouped by TTL for BatchDatabase and the
+// rest is written one by one.
+func (c *MultiTierCache) persistBatch(ctx context.Context, tasks []WriteTask) error {
+	if writer, ok := c.db.(BatchWriter); ok {
+		return writer.WriteBatch(ctx, tasks)
+	}
+	failed := make(map[string]error)
+	batchDB, canBatch := c.db.(BatchDatabase)
+	groups := make(map[time.Duration]map[string]string)
+	for _, task := range tasks {
+		if canBatch && task.SoftExpiry.IsZero() {
+			if groups[task.TTL] == nil {
+				groups[task.TTL] = make(map[string]string)
+			}
+			groups[task.TTL][task.Key] = task.Value
+			continue
+		}
+		if err := c.persist(ctx, task); err != nil {
+			failed[task.Key] = fmt.Errorf("persist key=%s: %w", task.Key, err)
+		}
+	}
+	for ttl, items := range groups {
+		if err := batchDB.MSet(ctx, items, ttl); err != nil {
+			for key := range items {
+				failed[key] = fmt.Errorf("persist key=%s: %w", key, err)
+			}
+		}
+	}
+	if len(failed) > 0 {
+		// Only the failed tasks are retried and dead-lettered
+		return &PartialWriteError{Failed: failed}
+	}
+	return nil
+}
+
+func isCacheMiss(err error) bool {
+	return errors.Is(err, ErrCacheMiss) || errors.Is(err, storage.ErrCacheMiss)
+}
+
+func uniqueKeys(keys []string) []string {
+	seen := make(map[string]struct{}, len(keys))
+	result := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		result = append(result, key)
+	}
+	return result
+}
+
+func withoutKeys(keys []string, found map[string]string) []string {
+	result := keys[:0]
+	for _, key := range keys {
+		if _, ok := found[key]; !ok {
+			result = append(result, key)
+		}
+	}
+	return result
+}
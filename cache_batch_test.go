@@ -0,0 +1,197 @@
+package multi_tier_caching
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/arturmon/multi-tier-caching/storage"
+	databaseMock "github.com/arturmon/multi-tier-caching/storage/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// batchTestStore is an in-memory layer and database with batch support that counts round-trips
+type batchTestStore struct {
+	mu     sync.Mutex
+	name   string
+	data   map[string]string
+	mgets  int
+	msets  int
+	single int
+	// deleteErr fails DeleteKey while set
+	deleteErr error
+}
+
+func newBatchTestStore(name string, data map[string]string) *batchTestStore {
+	if data == nil {
+		data = make(map[string]string)
+	}
+	return &batchTestStore{name: name, data: data}
+}
+
+func (s *batchTestStore) Get(ctx context.Context, key string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.single++
+	value, ok := s.data[key]
+	if !ok {
+		return "", ErrCacheMiss
+	}
+	return value, nil
+}
+
+func (s *batchTestStore) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.single++
+	s.data[key] = value
+	return nil
+}
+
+func (s *batchTestStore) Delete(ctx context.Context, key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.data, key)
+}
+
+func (s *batchTestStore) DeleteKey(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.deleteErr != nil {
+		return s.deleteErr
+	}
+	delete(s.data, key)
+	return nil
+}
+
+func (s *batchTestStore) MGet(ctx context.Context, keys []string) (map[string]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mgets++
+	result := make(map[string]string)
+	for _, key := range keys {
+		if value, ok := s.data[key]; ok {
+			result[key] = value
+		}
+	}
+	return result, nil
+}
+
+func (s *batchTestStore) MSet(ctx context.Context, items map[string]string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.msets++
+	for key, value := range items {
+		s.data[key] = value
+	}
+	return nil
+}
+
+func (s *batchTestStore) value(key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, ok := s.data[key]
+	return value, ok
+}
+
+func (s *batchTestStore) CheckHealth(ctx context.Context) error { return nil }
+
+func (s *batchTestStore) String() string { return s.name }
+
+func TestMultiTierCache_MGet(t *testing.T) {
+	ctx := context.Background()
+	hot := newBatchTestStore("hot", map[string]string{"k1": "v1"})
+	warm := newBatchTestStore("warm", map[string]string{"k2": "v2"})
+	db := newBatchTestStore("db", map[string]string{"k3": "v3"})
+
+	cache := newTestCache(t, MultiTierCacheConfig{
+		Layers:     []LayerInfo{NewLayerInfo(hot), NewLayerInfo(warm)},
+		DB:         db,
+		Thresholds: []int{10, 0},
+	})
+	cache.filter.Add("k3")
+
+	result, err := cache.MGet(ctx, []string{"k1", "k2", "k3", "k4", "k1"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"k1": "v1", "k2": "v2", "k3": "v3"}, result)
+
+	assert.Equal(t, 1, hot.mgets, "Hot layer must be queried with one batch")
+	assert.Equal(t, 1, warm.mgets, "Warm layer must be queried with one batch")
+	assert.Equal(t, 1, db.mgets, "Remaining keys must be read from the database with one batch")
+	assert.Equal(t, 0, db.single)
+
+	value, ok := warm.value("k3")
+	assert.True(t, ok, "Database hits must be placed into the target layers")
+	assert.Equal(t, "v3", value)
+}
+
+func TestMultiTierCache_MGet_SequentialDatabase(t *testing.T) {
+	ctx := context.Background()
+	db := new(databaseMock.MockDatabaseStorage)
+	db.On("Get", mock.Anything, "k1").Return("v1", nil)
+	db.On("Get", mock.Anything, "k2").Return("", storage.ErrCacheMiss)
+	db.On("Set", mock.Anything, mock.Anything, mock.Anything).Return(nil).Maybe()
+	cache := newTestCache(t, MultiTierCacheConfig{
+		Layers:     []LayerInfo{NewLayerInfo(newBatchTestStore("hot", nil))},
+		DB:         db,
+		Thresholds: []int{0},
+	})
+	cache.filter.Add("k1")
+	cache.filter.Add("k2")
+
+	// A database without batch support is read key by key, missing keys stay absent
+	result, err := cache.MGet(ctx, []string{"k1", "k2"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"k1": "v1"}, result)
+}
+
+func TestMultiTierCache_MSet(t *testing.T) {
+	ctx := context.Background()
+	hot := newBatchTestStore("hot", nil)
+	warm := newBatchTestStore("warm", nil)
+	db := newBatchTestStore("db", nil)
+
+	cache := newTestCache(t, MultiTierCacheConfig{
+		Layers:     []LayerInfo{NewLayerInfo(hot), NewLayerInfo(warm)},
+		DB:         db,
+		Thresholds: []int{10, 0},
+	})
+
+	assert.NoError(t, cache.MSet(ctx, map[string]string{"k1": "v1", "k2": "v2", "k3": "v3"}))
+
+	assert.Equal(t, 1, warm.msets, "All keys share the layer and TTL and must be written in one batch")
+	assert.Equal(t, 0, hot.msets, "No key is frequent enough for the hot layer")
+	for _, key := range []string{"k1", "k2", "k3"} {
+		_, ok := warm.value(key)
+		assert.True(t, ok)
+		assert.True(t, cache.filter.Exists(key))
+	}
+}
+
+func TestMultiTierCache_PersistBatch(t *testing.T) {
+	ctx := context.Background()
+	db := newBatchTestStore("db", nil)
+	cache := newTestCache(t, MultiTierCacheConfig{
+		Layers:      []LayerInfo{NewLayerInfo(newBatchTestStore("hot", nil))},
+		DB:          db,
+		Thresholds:  []int{0},
+		BloomHashes: 3,
+	})
+
+	err := cache.persistBatch(ctx, []WriteTask{
+		{Key: "k1", Value: "v1", TTL: time.Minute},
+		{Key: "k2", Value: "v2", TTL: time.Minute},
+		{Key: "k3", Value: "v3", TTL: time.Hour},
+	})
+	assert.NoError(t, err)
+
+	assert.Equal(t, 2, db.msets, "Tasks must be grouped by TTL")
+	assert.Equal(t, 0, db.single)
+	for key, expected := range map[string]string{"k1": "v1", "k2": "v2", "k3": "v3"} {
+		value, ok := db.value(key)
+		assert.True(t, ok)
+		assert.Equal(t, expected, value)
+	}
+}
@@ -2,11 +2,19 @@ package multi_tier_caching
 
 import (
 	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/arturmon/multi-tier-caching/mocks"
+	"github.com/arturmon/multi-tier-caching/storage"
 	databaseMock "github.com/arturmon/multi-tier-caching/storage/mocks"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
 func TestMultiTierCache_Get_CacheHit(t *testing.T) {
@@ -16,9 +24,9 @@ func TestMultiTierCache_Get_CacheHit(t *testing.T) {
 
 	// Настраиваем моки
 	mockCache.On("Get", ctx, "key1").Return("value1", nil)
-	mockCache.On("CheckHealth", ctx).Return(nil)
+	mockCache.On("CheckHealth", ctx).Return(nil).Maybe() // Covered by TestMultiTierCache_HealthCheck
 	mockDB.On("Get", ctx, "key1").Return("value1", nil)
-	mockDB.On("CheckHealth", ctx).Return(nil)
+	mockDB.On("CheckHealth", ctx).Return(nil).Maybe()
 
 	cacheConfig := MultiTierCacheConfig{
 		Layers: []LayerInfo{
@@ -36,6 +44,7 @@ func TestMultiTierCache_Get_CacheHit(t *testing.T) {
 		BloomSize:   100000,
 		BloomHashes: 5,
 		Debug:       false,
+		Registerer:  prometheus.NewRegistry(),
 	}
 
 	cache := NewMultiTierCache(ctx, cacheConfig)
@@ -45,3 +54,299 @@ func TestMultiTierCache_Get_CacheHit(t *testing.T) {
 	assert.Equal(t, "value1", value)
 	mockCache.AssertExpectations(t)
 }
+
+func TestMultiTierCache_HealthCheck(t *testing.T) {
+	ctx := context.Background()
+	mockCache := new(mocks.MockCacheLayer)
+	mockDB := new(databaseMock.MockDatabaseStorage)
+	mockCache.On("CheckHealth", ctx).Return(nil)
+	mockDB.On("CheckHealth", ctx).Return(nil)
+
+	cache := newTestCache(t, MultiTierCacheConfig{
+		Layers:     []LayerInfo{{Layer: mockCache, Name: "memory"}},
+		DB:         mockDB,
+		Thresholds: []int{0},
+	})
+	assert.NoError(t, cache.HealthCheck(ctx))
+	mockCache.AssertExpectations(t)
+	mockDB.AssertExpectations(t)
+}
+
+func TestMultiTierCache_Delete(t *testing.T) {
+	ctx := context.Background()
+	mockCache := new(mocks.MockCacheLayer)
+	mockDB := new(databaseMock.MockDatabaseStorage)
+
+	mockCache.On("Delete", ctx, "key1").Return()
+	mockDB.On("DeleteKey", ctx, "key1").Return(nil)
+
+	cache := newTestCache(t, MultiTierCacheConfig{
+		Layers: []LayerInfo{
+			{Layer: mockCache, Name: "memory"},
+			{Layer: mockCache, Name: "redis"},
+		},
+		DB:           mockDB,
+		Thresholds:   []int{10, 5},
+		DeleteFromDB: true,
+	})
+
+	cache.ttlManager.AdjustTTL("key1", 60)
+	cache.analytics.LogHit("layer_memory", "key1")
+	assert.NoError(t, cache.writeQueue.Enqueue(ctx, WriteTask{Key: "key1", Value: "value1"}))
+
+	assert.NoError(t, cache.Invalidate(ctx, "key1"))
+	assert.Equal(t, int64(0), cache.ttlManager.GetTTL("key1"))
+	assert.Zero(t, cache.analytics.TrackedKeys(), "Deleted keys must not be monitored")
+	assert.Equal(t, 0, cache.writeQueue.Cancel("key1"), "Pending task must be cancelled by Delete")
+	mockCache.AssertNumberOfCalls(t, "Delete", 2)
+	mockDB.AssertExpectations(t)
+}
+
+func TestMultiTierCache_DeleteDatabaseError(t *testing.T) {
+	ctx := context.Background()
+	db := newBatchTestStore("db", map[string]string{"key1": "value1"})
+	db.deleteErr = errors.New("connection refused")
+	cache := newTestCache(t, MultiTierCacheConfig{
+		Layers:       []LayerInfo{NewLayerInfo(newBatchTestStore("memory", nil))},
+		DB:           db,
+		Thresholds:   []int{0},
+		DeleteFromDB: true,
+	})
+
+	assert.ErrorContains(t, cache.Delete(ctx, "key1"), "connection refused")
+	_, ok := db.value("key1")
+	assert.True(t, ok)
+}
+
+func TestMultiTierCache_DeleteInFlightBatch(t *testing.T) {
+	ctx := context.Background()
+	db := &slowBatchStore{batchTestStore: newBatchTestStore("db", nil), started: make(chan struct{}), release: make(chan struct{})}
+	cache := newTestCache(t, MultiTierCacheConfig{
+		Layers:       []LayerInfo{NewLayerInfo(newBatchTestStore("memory", nil))},
+		DB:           db,
+		Thresholds:   []int{0},
+		DeleteFromDB: true,
+	})
+	release := sync.OnceFunc(func() { close(db.release) })
+	t.Cleanup(release)
+
+	// A worker takes the queued value and is persisting it
+	require.NoError(t, cache.Set(ctx, "key1", "value1"))
+	go func() { _ = cache.writeQueue.Flush(ctx) }()
+	<-db.started
+
+	done := make(chan error, 1)
+	go func() { done <- cache.Delete(ctx, "key1") }()
+	select {
+	case <-done:
+		t.Fatal("Delete must wait for the batch persisting the key")
+	case <-time.After(20 * time.Millisecond):
+	}
+	release()
+	require.NoError(t, <-done)
+
+	_, ok := db.value("key1")
+	assert.False(t, ok, "The batch must not resurrect the deleted key")
+}
+
+func TestMultiTierCache_GetOrLoad_SingleFlight(t *testing.T) {
+	ctx := context.Background()
+	cache, _ := newMemoryTestCache(t)
+
+	var calls int32
+	loader := func(ctx context.Context) (string, time.Duration, error) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(50 * time.Millisecond)
+		return "value1", time.Minute, nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			value, err := cache.GetOrLoad(ctx, "key1", loader)
+			assert.NoError(t, err)
+			assert.Equal(t, "value1", value)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls), "The loader must be called once for concurrent misses")
+
+	value, err := cache.Get(ctx, "key1")
+	assert.NoError(t, err)
+	assert.Equal(t, "value1", value, "The loaded value must be placed into the cache layers")
+}
+
+func TestMultiTierCache_GetOrLoad_CancelledCaller(t *testing.T) {
+	cache, _ := newMemoryTestCache(t)
+	release := make(chan struct{})
+	var calls int32
+	loader := func(ctx context.Context) (string, time.Duration, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return "value1", time.Minute, ctx.Err()
+	}
+
+	// The caller starting the load gives up, the caller sharing it still gets the value
+	first, cancel := context.WithCancel(context.Background())
+	firstErr := make(chan error, 1)
+	go func() {
+		_, err := cache.GetOrLoad(first, "key1", loader)
+		firstErr <- err
+	}()
+	for atomic.LoadInt32(&calls) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	shared := make(chan string, 1)
+	go func() {
+		value, err := cache.GetOrLoad(context.Background(), "key1", loader)
+		assert.NoError(t, err)
+		shared <- value
+	}()
+	cancel()
+	assert.ErrorIs(t, <-firstErr, context.Canceled)
+	time.Sleep(20 * time.Millisecond) // Let the second caller join the load
+	close(release)
+	assert.Equal(t, "value1", <-shared)
+}
+
+func TestMultiTierCache_Thresholds(t *testing.T) {
+	ctx := context.Background()
+	hot := newBatchTestStore("hot", nil)
+	cold := newBatchTestStore("cold", nil)
+	cache := newTestCache(t, MultiTierCacheConfig{
+		Layers:     []LayerInfo{NewLayerInfo(hot), NewLayerInfo(cold)},
+		DB:         newBatchTestStore("db", nil),
+		Thresholds: []int{1000, 0},
+	})
+
+	// A key requested less often than the threshold of the hot layer is only written to the cold one
+	require.NoError(t, cache.Set(ctx, "key1", "value1"))
+	_, inHot := hot.value("key1")
+	_, inCold := cold.value("key1")
+	assert.False(t, inHot)
+	assert.True(t, inCold)
+	assert.Equal(t, []string{"cold"}, layerNames(cache.selectTargetLayers(999)))
+	assert.Equal(t, []string{"hot", "cold"}, layerNames(cache.selectTargetLayers(1000)))
+}
+
+func layerNames(layers []LayerInfo) []string {
+	names := make([]string, len(layers))
+	for i, layer := range layers {
+		names[i] = layer.Name
+	}
+	return names
+}
+
+// blockingLayer is a layer whose writes wait until release is closed
+type blockingLayer struct {
+	*batchTestStore
+	release chan struct{}
+	sets    atomic.Int32
+}
+
+func (l *blockingLayer) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	l.sets.Add(1)
+	<-l.release
+	return l.batchTestStore.Set(ctx, key, value, ttl)
+}
+
+func TestMultiTierCache_BackfillAsync(t *testing.T) {
+	ctx := context.Background()
+	hot := &blockingLayer{batchTestStore: newBatchTestStore("hot", nil), release: make(chan struct{})}
+	cache := newTestCache(t, MultiTierCacheConfig{
+		Layers:     []LayerInfo{NewLayerInfo(hot), NewLayerInfo(newBatchTestStore("warm", map[string]string{"key1": "value1"}))},
+		DB:         newBatchTestStore("db", nil),
+		Thresholds: []int{10, 0},
+		Backfill:   BackfillAsync,
+	})
+
+	// The hits return without waiting for the hot layer and share one backfill
+	for range 50 {
+		value, err := cache.Get(ctx, "key1")
+		require.NoError(t, err)
+		assert.Equal(t, "value1", value)
+	}
+	close(hot.release)
+	cache.tasks.Wait()
+	assert.Equal(t, int32(1), hot.sets.Load())
+	value, ok := hot.value("key1")
+	assert.True(t, ok)
+	assert.Equal(t, "value1", value)
+
+	// Once it finished the key can be backfilled again
+	hot.Delete(ctx, "key1")
+	_, err := cache.Get(ctx, "key1")
+	require.NoError(t, err)
+	cache.tasks.Wait()
+	assert.Equal(t, int32(2), hot.sets.Load())
+}
+
+// newTestCache creates a cache closed with the test. Zero BloomSize, BloomHashes and Registerer
+// default to a 1000-bit filter with 5 hash functions and a registry of the test.
+func newTestCache(t *testing.T, config MultiTierCacheConfig) *MultiTierCache {
+	t.Helper()
+	if config.BloomSize == 0 {
+		config.BloomSize = 1000
+	}
+	if config.BloomHashes == 0 {
+		config.BloomHashes = 5
+	}
+	if config.Registerer == nil {
+		config.Registerer = prometheus.NewRegistry()
+	}
+	cache := NewMultiTierCache(context.Background(), config)
+	t.Cleanup(cache.Close)
+	return cache
+}
+
+// newMemoryTestCache builds a cache with a single Ristretto layer that accepts every key
+func newMemoryTestCache(t *testing.T) (*MultiTierCache, *databaseMock.MockDatabaseStorage) {
+	t.Helper()
+	ctx := context.Background()
+	ram, err := storage.NewRistrettoCache(ctx, 10, storage.WithRegisterer(prometheus.NewRegistry()))
+	require.NoError(t, err)
+	mockDB := new(databaseMock.MockDatabaseStorage)
+	mockDB.On("Set", mock.Anything, mock.Anything, mock.Anything).Return(nil).Maybe()
+
+	cache := newTestCache(t, MultiTierCacheConfig{
+		Layers:     []LayerInfo{NewLayerInfo(NewMemoryCache(ram))},
+		DB:         mockDB,
+		Thresholds: []int{0},
+	})
+	return cache, mockDB
+}
+
+func TestMultiTierCache_Backfill(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		policy   BackfillPolicy
+		expected bool
+	}{
+		{BackfillNone, false},
+		{BackfillAlways, true},
+		{BackfillFrequency, false}, // one request does not reach the hot threshold
+	}
+
+	for _, test := range tests {
+		t.Run(test.policy.String(), func(t *testing.T) {
+			hot := newBatchTestStore("hot", nil)
+			warm := newBatchTestStore("warm", map[string]string{"key1": "value1"})
+			cache := newTestCache(t, MultiTierCacheConfig{
+				Layers:     []LayerInfo{NewLayerInfo(hot), NewLayerInfo(warm)},
+				DB:         newBatchTestStore("db", nil),
+				Thresholds: []int{10, 0},
+				Backfill:   test.policy,
+			})
+
+			value, err := cache.Get(ctx, "key1")
+			assert.NoError(t, err)
+			assert.Equal(t, "value1", value)
+
+			_, ok := hot.value("key1")
+			assert.Equal(t, test.expected, ok)
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package multi_tier_caching
+
+import (
+	"log"
+	"math"
+	"sync"
+)
+
+// CountingBloomFilter is a Bloom filter of 8-bit counters instead of bits, so keys can be removed.
+// It does not grow: size it for the keys stored at once. A counter reaching 255 sticks there and
+// is never decremented again. Every Add is counted and must be matched by exactly one Remove:
+// removing a key that was not added decrements the counters of other keys and may make them
+// appear absent. The cache tracks the keys it added, up to the key capacity of the filter, so it
+// adds each of them once and removes only those.
+type CountingBloomFilter struct {
+	mu       sync.Mutex
+	counters []uint8
+	k        uint
+	count    uint // Keys added and not removed
+	debug    bool
+}
+
+// NewCountingBloomFilter creates a filter of size counters and hashFuncs hash functions
+func NewCountingBloomFilter(size uint, hashFuncs uint, debug bool) *CountingBloomFilter {
+	return &CountingBloomFilter{
+		counters: make([]uint8, max(size, 1)),
+		k:        max(hashFuncs, 1),
+		debug:    debug,
+	}
+}
+
+// Add counts the key, a key added twice needs two Remove calls
+func (f *CountingBloomFilter) Add(key string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, i := range hashLocations(key, f.k, uint64(len(f.counters))) {
+		if f.counters[i] < math.MaxUint8 {
+			f.counters[i]++
+		}
+	}
+	f.count++
+	if f.debug {
+		log.Printf("[BLOOM] Added key: %s", key)
+	}
+}
+
+func (f *CountingBloomFilter) Exists(key string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	exists := f.testLocked(hashLocations(key, f.k, uint64(len(f.counters))))
+	if f.debug {
+		log.Printf("[BLOOM] Check key=%s, exists=%v", key, exists)
+	}
+	return exists
+}
+
+// Remove forgets one Add of the key. Keys reported as absent cannot have been added and are ignored.
+func (f *CountingBloomFilter) Remove(key string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	locations := hashLocations(key, f.k, uint64(len(f.counters)))
+	if !f.testLocked(locations) {
+		return
+	}
+	for _, i := range locations {
+		if f.counters[i] < math.MaxUint8 {
+			f.counters[i]--
+		}
+	}
+	f.count--
+	if f.debug {
+		log.Printf("[BLOOM] Removed key: %s", key)
+	}
+}
+
+// Stats describes the counters of the filter
+func (f *CountingBloomFilter) Stats() FilterStats {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	size := uint(len(f.counters))
+	return FilterStats{
+		Capacity:          size,
+		Count:             f.count,
+		HashFunctions:     f.k,
+		FalsePositiveRate: estimateFalsePositiveRate(f.k, size, f.count),
+		LoadFactor:        float64(f.count) / float64(filterKeyCapacity(size, f.k)),
+		Stages:            1,
+	}
+}
+
+// testLocked reports whether every counter is set. The caller holds mu.
+func (f *CountingBloomFilter) testLocked(locations []uint64) bool {
+	for _, i := range locations {
+		if f.counters[i] == 0 {
+			return false
+		}
+	}
+	return true
+}
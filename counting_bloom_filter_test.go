@@ -0,0 +1,162 @@
+package multi_tier_caching
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCountingBloomFilter(t *testing.T) {
+	filter := NewCountingBloomFilter(10000, 5, false)
+	for i := range 500 {
+		filter.Add(fmt.Sprintf("key-%d", i))
+	}
+	filter.Add("key-0") // Every Add is counted
+	assert.Equal(t, uint(501), filter.Stats().Count)
+
+	for i := range 250 {
+		filter.Remove(fmt.Sprintf("key-%d", i))
+	}
+	for i := 250; i < 500; i++ {
+		assert.True(t, filter.Exists(fmt.Sprintf("key-%d", i)), "a key that was not removed stays present")
+	}
+	assert.True(t, filter.Exists("key-0"), "a key added twice needs two removals")
+	filter.Remove("key-0")
+	assert.False(t, filter.Exists("key-0"))
+	filter.Remove("absent")
+	assert.Equal(t, uint(250), filter.Stats().Count, "removing an absent key does nothing")
+
+	stats := filter.Stats()
+	assert.Equal(t, uint(10000), stats.Capacity)
+	assert.Equal(t, uint(5), stats.HashFunctions)
+	assert.Equal(t, 1, stats.Stages)
+	assert.Less(t, stats.FalsePositiveRate, 0.001)
+}
+
+func TestCountingBloomFilter_OverlappingKeys(t *testing.T) {
+	// 40 keys on 64 counters overlap heavily, a key often tests positive before it is added
+	filter := NewCountingBloomFilter(64, 3, false)
+	for i := range 40 {
+		filter.Add(fmt.Sprintf("key-%d", i))
+	}
+	for i := range 20 {
+		filter.Remove(fmt.Sprintf("key-%d", i))
+	}
+	for i := 20; i < 40; i++ {
+		assert.True(t, filter.Exists(fmt.Sprintf("key-%d", i)), "removing other keys must not cause false negatives")
+	}
+}
+
+func TestMembership_CountsKeysOnce(t *testing.T) {
+	// 256 counters and 3 hash functions hold 59 keys
+	m := newMembership(NewCountingBloomFilter(256, 3, false), nil, false, MetricsConfig{Registerer: prometheus.NewRegistry()})
+	t.Cleanup(m.Stop)
+	for range 3 {
+		for i := range 40 {
+			m.Add(fmt.Sprintf("key-%d", i)) // Stored keys are written again and again
+		}
+	}
+	assert.Equal(t, uint(40), m.filter.Stats().Count, "every key is counted once")
+
+	for range 2 {
+		for i := range 20 {
+			m.Remove(fmt.Sprintf("key-%d", i))
+		}
+	}
+	assert.False(t, m.Remove("never-added"), "keys that were not added are not removed")
+	assert.Equal(t, uint(20), m.filter.Stats().Count)
+	for i := 20; i < 40; i++ {
+		assert.True(t, m.Exists(fmt.Sprintf("key-%d", i)))
+	}
+}
+
+func TestMembership_CountedKeysBound(t *testing.T) {
+	// 64 counters and 3 hash functions hold 14 keys, the set of counted keys stops there
+	m := newMembership(NewCountingBloomFilter(64, 3, false), nil, false, MetricsConfig{Registerer: prometheus.NewRegistry()})
+	t.Cleanup(m.Stop)
+	for range 2 {
+		for i := range 20 {
+			m.Add(fmt.Sprintf("key-%d", i))
+		}
+	}
+	assert.Len(t, m.counted, 14)
+	assert.Equal(t, uint(14+6*2), m.filter.Stats().Count, "keys past the bound are added on every call")
+
+	assert.True(t, m.Remove("key-0"))
+	assert.False(t, m.Remove("key-19"), "keys past the bound are never removed")
+	for i := 1; i < 20; i++ {
+		assert.True(t, m.Exists(fmt.Sprintf("key-%d", i)))
+	}
+}
+
+func TestCountingBloomFilter_Saturation(t *testing.T) {
+	filter := NewCountingBloomFilter(1, 1, false)
+	filter.counters[0] = 255
+	filter.Remove("key")
+	assert.True(t, filter.Exists("key"), "a saturated counter is never decremented")
+}
+
+func TestMultiTierCache_MembershipFilterRemove(t *testing.T) {
+	ctx := context.Background()
+	db := newBatchTestStore("db", map[string]string{"stored": "value", "expiring": "value"})
+	layer := newBatchTestStore("memory", nil)
+	cache := newTestCache(t, MultiTierCacheConfig{
+		Layers:       []LayerInfo{NewLayerInfo(layer)},
+		DB:           db,
+		Thresholds:   []int{0},
+		Filter:       NewCountingBloomFilter(10000, 5, false),
+		DeleteFromDB: true,
+		WritePolicy:  WriteThrough,
+	})
+
+	// Deleting a key from the database removes it from the filter
+	require.NoError(t, cache.Set(ctx, "deleted", "value"))
+	assert.True(t, cache.filter.Exists("deleted"))
+	require.NoError(t, cache.Delete(ctx, "deleted"))
+	assert.False(t, cache.filter.Exists("deleted"))
+
+	// A key served from the database and expired there since is removed on the next miss
+	cache.filter.Add("expiring")
+	_, err := cache.Get(ctx, "expiring")
+	require.NoError(t, err)
+	db.Delete(ctx, "expiring")
+	layer.Delete(ctx, "expiring")
+	_, err = cache.Get(ctx, "expiring")
+	assert.ErrorIs(t, err, ErrCacheMiss)
+	assert.False(t, cache.filter.Exists("expiring"))
+
+	// A false positive the cache never stored is not removed, it would clear counters of other keys
+	cache.filter.Add("stored")
+	cache.filter.filter.Add("never-stored") // Positive in the filter without the cache adding it
+	_, err = cache.Get(ctx, "never-stored")
+	assert.ErrorIs(t, err, ErrCacheMiss)
+	assert.True(t, cache.filter.Exists("never-stored"))
+	value, err := cache.Get(ctx, "stored")
+	require.NoError(t, err)
+	assert.Equal(t, "value", value)
+}
+
+func TestMultiTierCache_FilterMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	filter := NewCountingBloomFilter(1000, 3, false)
+	m := newMembership(filter, nil, false, MetricsConfig{Registerer: reg})
+	t.Cleanup(m.Stop)
+	m.Add("key")
+	m.updateMetrics()
+
+	families, err := reg.Gather()
+	require.NoError(t, err)
+	values := make(map[string]float64)
+	for _, family := range families {
+		values[family.GetName()] = family.GetMetric()[0].GetGauge().GetValue()
+	}
+	assert.Equal(t, 1000.0, values["bloom_filter_capacity"])
+	assert.Equal(t, 1.0, values["bloom_filter_element_count"])
+	assert.Equal(t, 3.0, values["bloom_filter_hash_functions"])
+	assert.Equal(t, 1.0, values["bloom_filter_stages"])
+	assert.Equal(t, 1.0, values["bloom_filter_ready"])
+}
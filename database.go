@@ -35,14 +35,92 @@ func (d *DatabaseCache) Set(ctx context.Context, key string, value string, ttl t
 	return nil
 }
 
-// Delete removes a value from the database cache
-func (d *DatabaseCache) Delete(ctx context.Context, key string) {
-	err := d.storage.DeleteCache(ctx, key)
+// GetEntry retrieves the value with its metadata columns
+func (d *DatabaseCache) GetEntry(ctx context.Context, key string) (Entry, error) {
+	entry, err := d.storage.GetCacheEntry(ctx, key)
 	if err != nil {
+		return Entry{}, err
+	}
+	return Entry{Value: entry.Value, StoredAt: entry.StoredAt, SoftExpiry: entry.SoftExpiresAt}, nil
+}
+
+// SetEntry stores the value with its metadata columns and a hard TTL
+func (d *DatabaseCache) SetEntry(ctx context.Context, key string, entry Entry, ttl time.Duration) error {
+	return d.storage.SetCacheEntry(ctx, key, storage.CacheEntry{
+		Value:         entry.Value,
+		StoredAt:      entry.StoredAt,
+		SoftExpiresAt: entry.SoftExpiry,
+	}, ttl)
+}
+
+// MGet retrieves several values from the database cache in one query
+func (d *DatabaseCache) MGet(ctx context.Context, keys []string) (map[string]string, error) {
+	return d.storage.GetCacheMulti(ctx, keys)
+}
+
+// MSet stores several values in the database cache with TTL
+func (d *DatabaseCache) MSet(ctx context.Context, items map[string]string, ttl time.Duration) error {
+	return d.storage.SetCacheMulti(ctx, items, ttl)
+}
+
+// ScanKeys streams the keys stored in the cache table
+func (d *DatabaseCache) ScanKeys(ctx context.Context, fn func(key string) error) error {
+	return d.storage.ScanCacheKeys(ctx, fn)
+}
+
+// ScanKeysSince streams the keys stored in the cache table after since
+func (d *DatabaseCache) ScanKeysSince(ctx context.Context, since time.Time, fn func(key string) error) error {
+	return d.storage.ScanCacheKeysSince(ctx, since, fn)
+}
+
+// WriteBatch persists write-behind tasks with one multi-row upsert
+func (d *DatabaseCache) WriteBatch(ctx context.Context, tasks []WriteTask) error {
+	writes := make([]storage.CacheWrite, 0, len(tasks))
+	for _, task := range tasks {
+		writes = append(writes, storage.CacheWrite{
+			Key:           task.Key,
+			Value:         task.Value,
+			TTL:           task.TTL,
+			StoredAt:      task.StoredAt,
+			SoftExpiresAt: task.SoftExpiry,
+		})
+	}
+	return d.storage.SetCacheBatch(ctx, writes)
+}
+
+// Delete removes a value from the database cache when it serves as a layer
+func (d *DatabaseCache) Delete(ctx context.Context, key string) {
+	if err := d.DeleteKey(ctx, key); err != nil {
 		log.Printf("Failed to delete key=%s: %v", key, err)
 	}
 }
 
+// DeleteKey removes a value from the database cache and reports failures
+func (d *DatabaseCache) DeleteKey(ctx context.Context, key string) error {
+	return d.storage.DeleteCache(ctx, key)
+}
+
+// AddTags stores tag membership in the cache_tags table
+func (d *DatabaseCache) AddTags(ctx context.Context, key string, tags []string, ttl time.Duration) error {
+	return d.storage.AddCacheTags(ctx, key, tags, ttl)
+}
+
+func (d *DatabaseCache) TagKeys(ctx context.Context, tag string) ([]string, error) {
+	return d.storage.GetCacheTagKeys(ctx, tag)
+}
+
+func (d *DatabaseCache) RemoveTag(ctx context.Context, tag string) error {
+	return d.storage.DeleteCacheTag(ctx, tag)
+}
+
+func (d *DatabaseCache) Start(ctx context.Context) {
+	d.storage.Start(ctx)
+}
+
+func (d *DatabaseCache) Stop() {
+	d.storage.Stop()
+}
+
 func (d *DatabaseCache) Close() {
 	d.storage.Close()
 }
@@ -0,0 +1,290 @@
+package multi_tier_caching
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// RetryPolicy controls how a failed write-behind batch is retried before its tasks are dead-lettered.
+// Zero fields take the values of DefaultRetryPolicy.
+type RetryPolicy struct {
+	MaxAttempts int           // Attempts per batch including the first one, 1 disables retries
+	BaseDelay   time.Duration // Delay before the first retry, doubled on every attempt
+	MaxDelay    time.Duration // Upper bound of the delay
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 5,
+	BaseDelay:   100 * time.Millisecond,
+	MaxDelay:    10 * time.Second,
+}
+
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = DefaultRetryPolicy.MaxAttempts
+	}
+	if p.BaseDelay <= 0 {
+		p.BaseDelay = DefaultRetryPolicy.BaseDelay
+	}
+	if p.MaxDelay <= 0 {
+		p.MaxDelay = DefaultRetryPolicy.MaxDelay
+	}
+	return p
+}
+
+// backoff returns the delay after the given failed attempt: exponential with jitter in [delay/2, delay]
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempt && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	delay = min(delay, p.MaxDelay)
+	half := delay / 2
+	return half + rand.N(half+1)
+}
+
+// deadLetterFileName is the file of the dead letter sink used next to the write-ahead log
+const deadLetterFileName = "dead_letters.jsonl"
+
+// PartialWriteError is returned by a write-behind processor that persisted only part of a batch.
+// Only the failed tasks are retried and dead-lettered.
+type PartialWriteError struct {
+	Failed map[string]error // Error per key that was not persisted
+}
+
+func (e *PartialWriteError) Error() string {
+	return fmt.Sprintf("%d task(s) not persisted: %v", len(e.Failed), errors.Join(e.Unwrap()...))
+}
+
+func (e *PartialWriteError) Unwrap() []error {
+	keys := make([]string, 0, len(e.Failed))
+	for key := range e.Failed {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	errs := make([]error, len(keys))
+	for i, key := range keys {
+		errs[i] = e.Failed[key]
+	}
+	return errs
+}
+
+// failedTasks returns the tasks of the batch that were not persisted
+func (e *PartialWriteError) failedTasks(batch []WriteTask) []WriteTask {
+	var failed []WriteTask
+	for _, task := range batch {
+		if _, ok := e.Failed[task.Key]; ok {
+			failed = append(failed, task)
+		}
+	}
+	return failed
+}
+
+// DeadLetter — write-behind task that could not be persisted after all retries
+type DeadLetter struct {
+	Task     WriteTask `json:"task"`
+	Error    string    `json:"error"`
+	Attempts int       `json:"attempts"`
+	FailedAt time.Time `json:"failed_at"`
+}
+
+// DeadLetterSink stores dead letters until they are inspected or requeued
+type DeadLetterSink interface {
+	Put(letter DeadLetter) error
+	List() ([]DeadLetter, error)
+	// Take removes and returns the letters of the keys, all letters when no key is given
+	Take(keys ...string) ([]DeadLetter, error)
+}
+
+// MemoryDeadLetterSink keeps dead letters in memory, they are lost on restart
+type MemoryDeadLetterSink struct {
+	mu      sync.Mutex
+	letters []DeadLetter
+}
+
+func NewMemoryDeadLetterSink() *MemoryDeadLetterSink {
+	return &MemoryDeadLetterSink{}
+}
+
+func (s *MemoryDeadLetterSink) Put(letter DeadLetter) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.letters = append(s.letters, letter)
+	return nil
+}
+
+func (s *MemoryDeadLetterSink) List() ([]DeadLetter, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]DeadLetter(nil), s.letters...), nil
+}
+
+func (s *MemoryDeadLetterSink) Take(keys ...string) ([]DeadLetter, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	taken, kept := splitDeadLetters(s.letters, keys)
+	s.letters = kept
+	return taken, nil
+}
+
+// FileDeadLetterSink appends dead letters to a JSONL file, one letter per line
+type FileDeadLetterSink struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewFileDeadLetterSink creates the directory of the file, the file itself is created on the first Put
+func NewFileDeadLetterSink(path string) (*FileDeadLetterSink, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("create dead letter dir: %w", err)
+	}
+	return &FileDeadLetterSink{path: path}, nil
+}
+
+func (s *FileDeadLetterSink) Put(letter DeadLetter) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, err := json.Marshal(letter)
+	if err != nil {
+		return fmt.Errorf("encode dead letter: %w", err)
+	}
+	file, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("open dead letter file: %w", err)
+	}
+	if _, err = file.Write(append(data, '\n')); err != nil {
+		file.Close()
+		return fmt.Errorf("write dead letter: %w", err)
+	}
+	return file.Close()
+}
+
+func (s *FileDeadLetterSink) List() ([]DeadLetter, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.read()
+}
+
+func (s *FileDeadLetterSink) Take(keys ...string) ([]DeadLetter, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	letters, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+	taken, kept := splitDeadLetters(letters, keys)
+	if len(taken) == 0 {
+		return nil, nil
+	}
+	if err = s.rewrite(kept); err != nil {
+		return nil, err
+	}
+	return taken, nil
+}
+
+// read loads every letter, unreadable lines are skipped
+func (s *FileDeadLetterSink) read() ([]DeadLetter, error) {
+	file, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("open dead letter file: %w", err)
+	}
+	defer file.Close()
+
+	var letters []DeadLetter
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var letter DeadLetter
+		if err = json.Unmarshal(scanner.Bytes(), &letter); err != nil {
+			log.Printf("[DEAD LETTER] Skipping unreadable line in %s: %v", s.path, err)
+			continue
+		}
+		letters = append(letters, letter)
+	}
+	return letters, scanner.Err()
+}
+
+// rewrite atomically replaces the file with the letters
+func (s *FileDeadLetterSink) rewrite(letters []DeadLetter) error {
+	tmpPath := s.path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return fmt.Errorf("create dead letter file: %w", err)
+	}
+	writer := bufio.NewWriter(tmp)
+	encoder := json.NewEncoder(writer)
+	for _, letter := range letters {
+		if err = encoder.Encode(letter); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("write dead letter file: %w", err)
+	}
+	if err = os.Rename(tmpPath, s.path); err != nil {
+		return fmt.Errorf("replace dead letter file: %w", err)
+	}
+	return nil
+}
+
+// DeadLetters lists the queued writes that could not be persisted
+func (c *MultiTierCache) DeadLetters() ([]DeadLetter, error) {
+	return c.writeQueue.DeadLetters()
+}
+
+// RequeueDeadLetters puts the dead letters of the keys, or all of them, back into the write queue.
+// Letters older than the value the database holds are dropped, see WithSuperseded.
+func (c *MultiTierCache) RequeueDeadLetters(ctx context.Context, keys ...string) (int, error) {
+	return c.writeQueue.RequeueDeadLetters(ctx, keys...)
+}
+
+// supersededLetter reports whether the database holds a value of the key stored after the task.
+// Databases without entry metadata (EntryStore) cannot tell, their letters are always requeued.
+func (c *MultiTierCache) supersededLetter(ctx context.Context, task WriteTask) bool {
+	store, ok := c.db.(EntryStore)
+	if !ok {
+		return false
+	}
+	entry, err := store.GetEntry(ctx, task.Key)
+	if err != nil {
+		return false
+	}
+	return entry.StoredAt.After(task.StoredAt)
+}
+
+// splitDeadLetters separates the letters of the keys from the rest, no keys selects every letter
+func splitDeadLetters(letters []DeadLetter, keys []string) (taken, kept []DeadLetter) {
+	if len(keys) == 0 {
+		return letters, nil
+	}
+	selected := make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		selected[key] = struct{}{}
+	}
+	for _, letter := range letters {
+		if _, ok := selected[letter.Task.Key]; ok {
+			taken = append(taken, letter)
+		} else {
+			kept = append(kept, letter)
+		}
+	}
+	return taken, kept
+}
@@ -0,0 +1,235 @@
+package multi_tier_caching
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var fastRetry = RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond}
+
+func TestWriteQueue_RetriesFailedBatch(t *testing.T) {
+	var attempts int
+	var mu sync.Mutex
+
+	wq := NewWriteQueue(func(_ context.Context, tasks []WriteTask) error {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts++
+		if attempts < 3 {
+			return errors.New("database unavailable")
+		}
+		return nil
+	}, false, withTestMetrics(), WithMaxLinger(10*time.Millisecond), WithRetryPolicy(fastRetry))
+	defer wq.Stop()
+
+	assert.NoError(t, wq.Enqueue(context.Background(), WriteTask{Key: "key1", Value: "value1"}))
+
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return attempts == 3
+	}, 2*time.Second, 10*time.Millisecond)
+	letters, err := wq.DeadLetters()
+	require.NoError(t, err)
+	assert.Empty(t, letters, "A batch that succeeded on retry must not be dead-lettered")
+}
+
+func TestWriteQueue_DeadLetterAndRequeue(t *testing.T) {
+	var persisted []WriteTask
+	var mu sync.Mutex
+	failing := true
+
+	wq := NewWriteQueue(func(_ context.Context, tasks []WriteTask) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if failing {
+			return errors.New("database unavailable")
+		}
+		persisted = append(persisted, tasks...)
+		return nil
+	}, false, withTestMetrics(), WithMaxLinger(10*time.Millisecond), WithRetryPolicy(fastRetry))
+	defer wq.Stop()
+
+	assert.NoError(t, wq.Enqueue(context.Background(), WriteTask{Key: "key1", Value: "value1"}))
+	assert.NoError(t, wq.Enqueue(context.Background(), WriteTask{Key: "key2", Value: "value2"}))
+
+	var letters []DeadLetter
+	assert.Eventually(t, func() bool {
+		letters, _ = wq.DeadLetters()
+		return len(letters) == 2
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 3, letters[0].Attempts)
+	assert.Equal(t, "database unavailable", letters[0].Error)
+
+	mu.Lock()
+	failing = false
+	mu.Unlock()
+
+	requeued, err := wq.RequeueDeadLetters(context.Background(), "key2")
+	require.NoError(t, err)
+	assert.Equal(t, 1, requeued)
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(persisted) == 1
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, "value2", persisted[0].Value)
+
+	letters, err = wq.DeadLetters()
+	require.NoError(t, err)
+	require.Len(t, letters, 1)
+	assert.Equal(t, "key1", letters[0].Task.Key)
+}
+
+func TestWriteQueue_FlushReportsDeadLetters(t *testing.T) {
+	wq := NewWriteQueue(func(_ context.Context, tasks []WriteTask) error {
+		for _, task := range tasks {
+			if task.Key == "bad" {
+				return &PartialWriteError{Failed: map[string]error{"bad": errors.New("value too large")}}
+			}
+		}
+		return nil
+	}, false, withTestMetrics(), WithMaxLinger(time.Hour), WithRetryPolicy(fastRetry))
+
+	ctx := context.Background()
+	assert.NoError(t, wq.Enqueue(ctx, WriteTask{Key: "good", Value: "value"}))
+	assert.NoError(t, wq.Enqueue(ctx, WriteTask{Key: "bad", Value: "value"}))
+	err := wq.Flush(ctx)
+	assert.ErrorIs(t, err, ErrDeadLettered, "Flush must not report a dead-lettered task as persisted")
+	assert.ErrorContains(t, err, "1 task(s)")
+
+	// Later flushes only report their own tasks
+	assert.NoError(t, wq.Enqueue(ctx, WriteTask{Key: "good", Value: "value2"}))
+	assert.NoError(t, wq.Flush(ctx))
+
+	assert.NoError(t, wq.Enqueue(ctx, WriteTask{Key: "bad", Value: "value2"}))
+	unpersisted, err := wq.Shutdown(ctx)
+	assert.ErrorIs(t, err, ErrDeadLettered)
+	assert.Equal(t, 1, unpersisted, "Dead-lettered tasks must be reported as unpersisted")
+}
+
+func TestFileDeadLetterSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dead", "letters.jsonl")
+	sink, err := NewFileDeadLetterSink(path)
+	require.NoError(t, err)
+
+	failedAt := time.Now().Truncate(time.Second)
+	for _, key := range []string{"key1", "key2", "key3"} {
+		require.NoError(t, sink.Put(DeadLetter{
+			Task:     WriteTask{Key: key, Value: "value", TTL: time.Minute},
+			Error:    "timeout",
+			Attempts: 5,
+			FailedAt: failedAt,
+		}))
+	}
+
+	taken, err := sink.Take("key2")
+	require.NoError(t, err)
+	require.Len(t, taken, 1)
+	assert.Equal(t, "key2", taken[0].Task.Key)
+	assert.Equal(t, time.Minute, taken[0].Task.TTL)
+	assert.True(t, failedAt.Equal(taken[0].FailedAt))
+
+	// A new sink on the same file sees what is left
+	sink, err = NewFileDeadLetterSink(path)
+	require.NoError(t, err)
+	letters, err := sink.List()
+	require.NoError(t, err)
+	require.Len(t, letters, 2)
+	assert.Equal(t, "key1", letters[0].Task.Key)
+	assert.Equal(t, "key3", letters[1].Task.Key)
+
+	taken, err = sink.Take()
+	require.NoError(t, err)
+	assert.Len(t, taken, 2)
+	letters, err = sink.List()
+	require.NoError(t, err)
+	assert.Empty(t, letters)
+}
+
+func TestWriteQueue_PartialBatchFailure(t *testing.T) {
+	var mu sync.Mutex
+	var batches [][]string
+	wq := NewWriteQueue(func(_ context.Context, tasks []WriteTask) error {
+		mu.Lock()
+		defer mu.Unlock()
+		var keys []string
+		for _, task := range tasks {
+			keys = append(keys, task.Key)
+		}
+		batches = append(batches, keys)
+		return &PartialWriteError{Failed: map[string]error{"key2": errors.New("value too long")}}
+	}, false, withTestMetrics(), WithBatchSize(2), WithMaxLinger(time.Hour), WithRetryPolicy(fastRetry))
+	defer wq.Stop()
+
+	require.NoError(t, wq.Enqueue(context.Background(), WriteTask{Key: "key1", Value: "value1"}))
+	require.NoError(t, wq.Enqueue(context.Background(), WriteTask{Key: "key2", Value: "value2"}))
+	assert.ErrorIs(t, wq.Flush(context.Background()), ErrDeadLettered)
+
+	letters, err := wq.DeadLetters()
+	require.NoError(t, err)
+	require.Len(t, letters, 1, "Tasks the processor persisted must not be dead-lettered")
+	assert.Equal(t, "key2", letters[0].Task.Key)
+	assert.Equal(t, "value too long", letters[0].Error)
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, [][]string{{"key1", "key2"}, {"key2"}, {"key2"}}, batches, "Only the failed tasks are retried")
+}
+
+func TestWriteQueue_RequeueSupersededLetter(t *testing.T) {
+	sink := NewMemoryDeadLetterSink()
+	failedAt := time.Now()
+	for _, key := range []string{"key1", "key2"} {
+		require.NoError(t, sink.Put(DeadLetter{Task: WriteTask{Key: key, Value: "old", StoredAt: failedAt}}))
+	}
+	var persisted []WriteTask
+	var mu sync.Mutex
+	wq := NewWriteQueue(func(_ context.Context, tasks []WriteTask) error {
+		mu.Lock()
+		defer mu.Unlock()
+		persisted = append(persisted, tasks...)
+		return nil
+	}, false, withTestMetrics(), WithDeadLetterSink(sink), WithSuperseded(func(ctx context.Context, task WriteTask) bool {
+		return task.Key == "key1" // key1 was written again after the letter failed
+	}))
+	defer wq.Stop()
+
+	requeued, err := wq.RequeueDeadLetters(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, requeued)
+	require.NoError(t, wq.Flush(context.Background()))
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, persisted, 1)
+	assert.Equal(t, "key2", persisted[0].Key)
+	letters, _ := sink.List()
+	assert.Empty(t, letters, "A superseded letter is dropped")
+}
+
+func TestMultiTierCache_SupersededLetter(t *testing.T) {
+	ctx := context.Background()
+	stored := time.Now()
+	db := &entryTestStore{newBatchTestStore("db", map[string]string{
+		"key1": encodeEntry(Entry{Value: "new", StoredAt: stored}),
+	})}
+	cache := newTestCache(t, MultiTierCacheConfig{
+		Layers:     []LayerInfo{NewLayerInfo(newBatchTestStore("memory", nil))},
+		DB:         db,
+		Thresholds: []int{0},
+		WALDir:     t.TempDir(),
+	})
+
+	assert.True(t, cache.supersededLetter(ctx, WriteTask{Key: "key1", StoredAt: stored.Add(-time.Second)}))
+	assert.False(t, cache.supersededLetter(ctx, WriteTask{Key: "key1", StoredAt: stored.Add(time.Second)}))
+	assert.False(t, cache.supersededLetter(ctx, WriteTask{Key: "key2", StoredAt: stored}))
+
+	// With a write-ahead log the dead letters are kept next to it
+	assert.IsType(t, &FileDeadLetterSink{}, cache.writeQueue.deadLetters)
+}
@@ -0,0 +1,53 @@
+package multi_tier_caching
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// entryPrefix marks values stored together with entry metadata in string-only stores (Redis)
+const entryPrefix = "\x00mtc:entry:v1\x00"
+
+// encodeEntry packs the value and its metadata into one string:
+// prefix, stored-at and soft expiry in unix nanoseconds, value
+func encodeEntry(entry Entry) string {
+	var softExpiry int64
+	if !entry.SoftExpiry.IsZero() {
+		softExpiry = entry.SoftExpiry.UnixNano()
+	}
+	var b strings.Builder
+	b.Grow(len(entryPrefix) + len(entry.Value) + 42)
+	b.WriteString(entryPrefix)
+	b.WriteString(strconv.FormatInt(entry.StoredAt.UnixNano(), 10))
+	b.WriteByte(0)
+	b.WriteString(strconv.FormatInt(softExpiry, 10))
+	b.WriteByte(0)
+	b.WriteString(entry.Value)
+	return b.String()
+}
+
+// decodeEntry unpacks a string written by encodeEntry, plain values are returned as entries without metadata
+func decodeEntry(data string) Entry {
+	rest, ok := strings.CutPrefix(data, entryPrefix)
+	if !ok {
+		return Entry{Value: data}
+	}
+	parts := strings.SplitN(rest, "\x00", 3)
+	if len(parts) != 3 {
+		return Entry{Value: data}
+	}
+	storedAt, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return Entry{Value: data}
+	}
+	softExpiry, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return Entry{Value: data}
+	}
+	entry := Entry{Value: parts[2], StoredAt: time.Unix(0, storedAt)}
+	if softExpiry != 0 {
+		entry.SoftExpiry = time.Unix(0, softExpiry)
+	}
+	return entry
+}
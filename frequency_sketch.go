@@ -0,0 +1,112 @@
+package multi_tier_caching
+
+import (
+	"hash/maphash"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	sketchDepth = 4
+	// DefaultSketchWidth is the number of counters per row of the frequency sketch
+	DefaultSketchWidth = 1 << 14
+	// sketchRenormalizeAt is the increment weight at which the counters are rescaled
+	sketchRenormalizeAt = 1 << 20
+)
+
+// frequencySketch is a count-min sketch: approximate request counts per key in fixed memory.
+// An estimate never undercounts, collisions can only inflate it. With a half-life the counts
+// decay exponentially: a request weighs 1 now and 1/2 after one half-life. Instead of decaying
+// every counter, later requests are added with a growing weight and the counters are rescaled
+// once the weight gets large, so both increments and estimates stay O(depth).
+type frequencySketch struct {
+	mu       sync.Mutex
+	seed     maphash.Seed
+	mask     uint64 // Width - 1, the width is a power of two
+	rows     [sketchDepth][]float64
+	halfLife time.Duration // 0 disables the decay, the sketch then keeps lifetime counts
+	epoch    time.Time     // Time at which a request weighs 1 in the stored counters
+	now      func() time.Time
+}
+
+// newFrequencySketch creates a sketch with at least width counters per row
+func newFrequencySketch(width int, halfLife time.Duration) *frequencySketch {
+	size := 1
+	for size < width {
+		size <<= 1
+	}
+	s := &frequencySketch{
+		seed:     maphash.MakeSeed(),
+		mask:     uint64(size - 1),
+		halfLife: halfLife,
+		now:      time.Now,
+	}
+	for i := range s.rows {
+		s.rows[i] = make([]float64, size)
+	}
+	s.epoch = s.now()
+	return s
+}
+
+// indexes returns the counter of the key in every row, derived from one hash by double hashing
+func (s *frequencySketch) indexes(key string) [sketchDepth]uint64 {
+	h := maphash.String(s.seed, key)
+	h1, h2 := h&0xffffffff, h>>32|1
+	var idx [sketchDepth]uint64
+	for i := range idx {
+		idx[i] = (h1 + uint64(i)*h2) & s.mask
+	}
+	return idx
+}
+
+// Increment counts one request of the key and returns its new estimate. Only the smallest
+// counters are raised (conservative update), which keeps collisions from inflating other keys.
+func (s *frequencySketch) Increment(key string) float64 {
+	idx := s.indexes(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	weight := s.weightLocked()
+	estimate := s.estimateLocked(idx) + weight
+	for i, j := range idx {
+		s.rows[i][j] = max(s.rows[i][j], estimate)
+	}
+	return estimate / weight
+}
+
+// Estimate returns the decayed number of requests of the key
+func (s *frequencySketch) Estimate(key string) float64 {
+	idx := s.indexes(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	weight := s.weightLocked() // May rescale the counters, so it comes first
+	return s.estimateLocked(idx) / weight
+}
+
+func (s *frequencySketch) estimateLocked(idx [sketchDepth]uint64) float64 {
+	estimate := s.rows[0][idx[0]]
+	for i := 1; i < sketchDepth; i++ {
+		estimate = min(estimate, s.rows[i][idx[i]])
+	}
+	return estimate
+}
+
+// weightLocked returns the weight of a request made now, rescaling the counters when it
+// grows too large. The caller holds mu.
+func (s *frequencySketch) weightLocked() float64 {
+	if s.halfLife <= 0 {
+		return 1
+	}
+	now := s.now()
+	weight := math.Exp2(float64(now.Sub(s.epoch)) / float64(s.halfLife))
+	if weight < sketchRenormalizeAt {
+		return weight
+	}
+	for _, row := range s.rows {
+		for j := range row {
+			row[j] /= weight
+		}
+	}
+	s.epoch = now
+	return 1
+}
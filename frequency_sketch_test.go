@@ -0,0 +1,113 @@
+package multi_tier_caching
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFrequencySketch(t *testing.T) {
+	now := time.Now()
+	sketch := newFrequencySketch(1024, time.Minute)
+	sketch.now, sketch.epoch = func() time.Time { return now }, now
+
+	counts := make(map[string]float64)
+	for i := range 2000 {
+		key := fmt.Sprintf("key-%d", i%500)
+		sketch.Increment(key)
+		counts[key]++
+	}
+	for key, count := range counts {
+		assert.GreaterOrEqual(t, sketch.Estimate(key), count, "an estimate never undercounts")
+	}
+	assert.LessOrEqual(t, sketch.Estimate("unknown"), 8.0, "an unknown key has a small estimate")
+	for _, row := range sketch.rows {
+		assert.Len(t, row, 1024, "the sketch does not grow with the number of keys")
+	}
+
+	for range 40 {
+		sketch.Increment("hot")
+	}
+	assert.InDelta(t, 40, sketch.Estimate("hot"), 4)
+
+	now = now.Add(30 * time.Second)
+	assert.InDelta(t, 40/1.4142, sketch.Estimate("hot"), 4, "counts decay continuously")
+	now = now.Add(30 * time.Second)
+	assert.InDelta(t, 20, sketch.Estimate("hot"), 4, "counts are halved after one half-life")
+
+	// Far in the future the weights are rescaled and old requests no longer count
+	now = now.Add(time.Hour)
+	sketch.Increment("hot")
+	assert.InDelta(t, 1, sketch.Estimate("hot"), 0.01)
+}
+
+func TestCacheAnalytics_Frequency(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	analytics := NewCacheAnalytics(WithAnalyticsMetrics(MetricsConfig{Registerer: reg}),
+		WithHalfLife(time.Minute), WithFrequencyWindow(time.Minute), WithTrackedKeys(3))
+	now := time.Now()
+	clock := func() time.Time { return now }
+	analytics.frequency.now, analytics.frequency.epoch = clock, now
+	analytics.hitters.now, analytics.hitters.epoch = clock, now
+
+	// A key requested once per second for a long time settles at about 60 requests per minute
+	for range 600 {
+		analytics.LogHit("memory", "steady")
+		now = now.Add(time.Second)
+	}
+	assert.InDelta(t, 60, analytics.GetFrequency("steady"), 3)
+	assert.InDelta(t, 1, analytics.Rate("steady"), 0.05)
+	assert.Equal(t, uint64(600), analytics.Count("steady"))
+
+	// A week later the key is cold again but its lifetime count remains
+	now = now.Add(7 * 24 * time.Hour)
+	assert.Equal(t, 0, analytics.GetFrequency("steady"))
+	assert.Equal(t, uint64(600), analytics.Count("steady"))
+
+	for i := range 5 {
+		analytics.LogHit("memory", fmt.Sprintf("key-%d", i))
+	}
+	assert.Len(t, analytics.GetFrequencyPerMinute(), 3, "the tracked keys are bounded")
+
+	families, err := reg.Gather()
+	assert.NoError(t, err)
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				assert.NotEqual(t, "key", label.GetName(), "%s must not have a series per key", family.GetName())
+			}
+		}
+	}
+
+	// Keys whose requests decayed make room for the new ones
+	now = now.Add(5 * time.Minute)
+	analytics.LogHit("memory", "key-4")
+	assert.Equal(t, map[string]int{"key-4": 1}, analytics.GetFrequencyPerMinute())
+
+	analytics.Forget("key-4")
+	assert.NotContains(t, analytics.GetFrequencyPerMinute(), "key-4")
+	assert.Equal(t, 2, analytics.TrackedKeys())
+}
+
+func TestCacheAnalytics_ForgetCollidingKeys(t *testing.T) {
+	analytics := NewCacheAnalytics(WithAnalyticsMetrics(MetricsConfig{Registerer: prometheus.NewRegistry()}))
+	// Four counters per row, so every key collides with others
+	analytics.lifetime = newFrequencySketch(4, 0)
+
+	counts := make(map[string]uint64)
+	for i := range 200 {
+		key := fmt.Sprintf("key-%d", i%20)
+		analytics.LogHit("memory", key)
+		counts[key]++
+	}
+	for i := range 10 {
+		analytics.Forget(fmt.Sprintf("key-%d", i))
+	}
+	for i := 10; i < 20; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		assert.GreaterOrEqual(t, analytics.Count(key), counts[key], "Forget must not undercount colliding keys")
+	}
+}
@@ -0,0 +1,164 @@
+package multi_tier_caching
+
+import (
+	"container/heap"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// KeyStat describes one of the hottest keys
+type KeyStat struct {
+	Key   string
+	Rate  float64 // Recent requests per second
+	Count uint64  // Requests since the analytics were created
+	Layer string  // Layer that served the last request, "database" or "loader" when no cache layer had the key
+}
+
+// hitterEntry is a monitored key of the space-saving structure
+type hitterEntry struct {
+	key   string
+	layer string
+	count float64 // Decayed count scaled like the weights, overestimated by at most err
+	err   float64 // Count inherited from the evicted key
+	index int     // Position in the heap
+}
+
+// heavyHitters finds the most requested keys with the space-saving algorithm: at most
+// capacity keys are monitored, a new key replaces the least requested one and inherits its
+// count. Any key requested more often than 1/capacity of the (decayed) traffic is monitored.
+// Counts decay with the half-life like the frequency sketch.
+type heavyHitters struct {
+	mu       sync.Mutex
+	capacity int
+	entries  hitterHeap // Min-heap on count, the root is evicted first
+	byKey    map[string]*hitterEntry
+	halfLife time.Duration
+	epoch    time.Time
+	now      func() time.Time
+}
+
+func newHeavyHitters(capacity int, halfLife time.Duration) *heavyHitters {
+	h := &heavyHitters{
+		capacity: capacity,
+		byKey:    make(map[string]*hitterEntry, capacity),
+		halfLife: halfLife,
+		now:      time.Now,
+	}
+	h.epoch = h.now()
+	return h
+}
+
+// Add counts one request of the key served by the layer
+func (h *heavyHitters) Add(key, layer string) {
+	layer = strings.TrimPrefix(layer, "layer_")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	weight := h.weightLocked()
+	if entry, ok := h.byKey[key]; ok {
+		entry.count += weight
+		entry.layer = layer
+		heap.Fix(&h.entries, entry.index)
+		return
+	}
+	if len(h.entries) < h.capacity {
+		entry := &hitterEntry{key: key, layer: layer, count: weight}
+		heap.Push(&h.entries, entry)
+		h.byKey[key] = entry
+		return
+	}
+	// Replace the least requested key, its count becomes the error bound of the new one
+	entry := h.entries[0]
+	delete(h.byKey, entry.key)
+	entry.key, entry.layer = key, layer
+	entry.err = entry.count
+	entry.count += weight
+	h.byKey[key] = entry
+	heap.Fix(&h.entries, 0)
+}
+
+// Remove stops monitoring the key
+func (h *heavyHitters) Remove(key string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if entry, ok := h.byKey[key]; ok {
+		heap.Remove(&h.entries, entry.index)
+		delete(h.byKey, key)
+	}
+}
+
+// Len returns the number of monitored keys
+func (h *heavyHitters) Len() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.entries)
+}
+
+// Top returns up to n monitored keys with their layers, most requested first.
+// n <= 0 returns every monitored key.
+func (h *heavyHitters) Top(n int) []hitterEntry {
+	h.mu.Lock()
+	top := make([]hitterEntry, len(h.entries))
+	for i, entry := range h.entries {
+		top[i] = *entry
+	}
+	h.mu.Unlock()
+
+	// Rank by the guaranteed part of the count, inherited counts only break ties
+	sort.Slice(top, func(i, j int) bool {
+		gi, gj := top[i].count-top[i].err, top[j].count-top[j].err
+		if gi != gj {
+			return gi > gj
+		}
+		return top[i].count > top[j].count
+	})
+	if n > 0 && n < len(top) {
+		top = top[:n]
+	}
+	return top
+}
+
+// weightLocked returns the weight of a request made now, rescaling the counts when it
+// grows too large. The caller holds mu.
+func (h *heavyHitters) weightLocked() float64 {
+	if h.halfLife <= 0 {
+		return 1
+	}
+	now := h.now()
+	weight := math.Exp2(float64(now.Sub(h.epoch)) / float64(h.halfLife))
+	if weight < sketchRenormalizeAt {
+		return weight
+	}
+	for _, entry := range h.entries {
+		entry.count /= weight
+		entry.err /= weight
+	}
+	h.epoch = now
+	return 1
+}
+
+type hitterHeap []*hitterEntry
+
+func (h hitterHeap) Len() int           { return len(h) }
+func (h hitterHeap) Less(i, j int) bool { return h[i].count < h[j].count }
+func (h hitterHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *hitterHeap) Push(x any) {
+	entry := x.(*hitterEntry)
+	entry.index = len(*h)
+	*h = append(*h, entry)
+}
+
+func (h *hitterHeap) Pop() any {
+	old := *h
+	entry := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return entry
+}
@@ -0,0 +1,62 @@
+package multi_tier_caching
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCacheAnalytics_TopK(t *testing.T) {
+	analytics := NewCacheAnalytics(WithAnalyticsMetrics(MetricsConfig{Registerer: prometheus.NewRegistry()}), WithTrackedKeys(100))
+
+	// Three hot keys hidden in a stream of 1000 keys requested once each
+	for i := range 1000 {
+		analytics.LogHit("database", fmt.Sprintf("cold-%d", i))
+		if i%10 == 0 {
+			analytics.LogHit("layer_memory", "hot-a")
+			analytics.LogHit("layer_memory", "hot-a")
+		}
+		if i%20 == 0 {
+			analytics.LogHit("layer_redis", "hot-b")
+		}
+		if i%25 == 0 {
+			analytics.LogHit("database", "hot-c")
+		}
+	}
+	assert.Equal(t, 100, analytics.TrackedKeys(), "the monitored keys are bounded")
+
+	top := analytics.TopK(3)
+	require.Len(t, top, 3)
+	assert.Equal(t, []string{"hot-a", "hot-b", "hot-c"}, []string{top[0].Key, top[1].Key, top[2].Key})
+	assert.Equal(t, "memory", top[0].Layer)
+	assert.Equal(t, "redis", top[1].Layer)
+	assert.Equal(t, "database", top[2].Layer)
+	assert.Equal(t, uint64(200), top[0].Count)
+	assert.Greater(t, top[0].Rate, top[1].Rate)
+
+	analytics.Forget("hot-a")
+	assert.Equal(t, "hot-b", analytics.TopK(1)[0].Key)
+}
+
+func TestHeavyHitters_Decay(t *testing.T) {
+	now := time.Now()
+	hitters := newHeavyHitters(2, time.Minute)
+	hitters.now, hitters.epoch = func() time.Time { return now }, now
+
+	for range 100 {
+		hitters.Add("old", "memory")
+	}
+	now = now.Add(time.Hour)
+	hitters.Add("new", "memory")
+	hitters.Add("new", "memory")
+	hitters.Add("newer", "memory")
+
+	top := hitters.Top(0)
+	require.Len(t, top, 2)
+	assert.Equal(t, "new", top[0].key, "a key hot an hour ago is replaced by the current traffic")
+	assert.Equal(t, "newer", top[1].key)
+}
@@ -0,0 +1,91 @@
+package multi_tier_caching
+
+import (
+	"context"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/arturmon/multi-tier-caching/storage"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMultiTierCache_CloseStopsGoroutines(t *testing.T) {
+	ctx := context.Background()
+	baseline := runtime.NumGoroutine()
+
+	ram, err := storage.NewRistrettoCache(ctx, 10, storage.WithRegisterer(prometheus.NewRegistry()))
+	require.NoError(t, err)
+	db := newBatchTestStore("db", map[string]string{"key2": "value2"})
+	cache := NewMultiTierCache(ctx, MultiTierCacheConfig{
+		Layers:         []LayerInfo{NewLayerInfo(NewMemoryCache(ram))},
+		DB:             db,
+		Thresholds:     []int{0},
+		BloomSize:      1000,
+		BloomHashes:    3,
+		WriteWorkers:   4,
+		Backfill:       BackfillAsync,
+		SoftTTLRatio:   0.5,
+		WriteMaxLinger: time.Hour,
+		Registerer:     prometheus.NewRegistry(),
+	})
+
+	assert.NoError(t, cache.Set(ctx, "key1", "value1"))
+	_, _ = cache.Get(ctx, "key1")
+	cache.filter.Add("key2")
+	_, _ = cache.Get(ctx, "key2")
+	assert.Greater(t, runtime.NumGoroutine(), baseline)
+
+	cache.Close()
+	ram.Close()
+
+	value, ok := db.value("key1")
+	assert.True(t, ok, "Close must drain the write queue")
+	assert.Equal(t, "value1", value)
+	// assert.Eventually runs the condition in goroutines of its own, so poll by hand
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > baseline && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+	}
+	assert.LessOrEqual(t, runtime.NumGoroutine(), baseline, "goroutines leaked")
+}
+
+// lifecycleStore records whether it was stopped or closed
+type lifecycleStore struct {
+	*batchTestStore
+	stopped atomic.Bool
+	closed  atomic.Bool
+}
+
+func (s *lifecycleStore) Start(ctx context.Context) {}
+
+func (s *lifecycleStore) Stop() { s.stopped.Store(true) }
+
+func (s *lifecycleStore) Close() error {
+	s.closed.Store(true)
+	return nil
+}
+
+func TestMultiTierCache_CloseStopsComponents(t *testing.T) {
+	for _, keepOpen := range []bool{false, true} {
+		layer := &lifecycleStore{batchTestStore: newBatchTestStore("memory", nil)}
+		db := &lifecycleStore{batchTestStore: newBatchTestStore("db", nil)}
+		cache := NewMultiTierCache(context.Background(), MultiTierCacheConfig{
+			Layers:             []LayerInfo{NewLayerInfo(layer)},
+			DB:                 db,
+			Thresholds:         []int{0},
+			BloomSize:          1000,
+			BloomHashes:        3,
+			KeepComponentsOpen: keepOpen,
+			Registerer:         prometheus.NewRegistry(),
+		})
+
+		cache.Close()
+		assert.Equal(t, !keepOpen, layer.stopped.Load(), "keepOpen=%v: layer stopped", keepOpen)
+		assert.Equal(t, !keepOpen, db.stopped.Load(), "keepOpen=%v: database stopped", keepOpen)
+		assert.Equal(t, !keepOpen, db.closed.Load(), "keepOpen=%v: database closed", keepOpen)
+	}
+}
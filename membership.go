@@ -0,0 +1,208 @@
+package multi_tier_caching
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/arturmon/multi-tier-caching/storage"
+)
+
+// FilterStats describes a membership filter for its gauges
+type FilterStats struct {
+	Capacity          uint      // Bits or counters
+	Count             uint      // Keys in the filter
+	HashFunctions     uint      // Hash functions of the newest stage
+	FalsePositiveRate float64   // Estimated from Count
+	LoadFactor        float64   // Count divided by the keys the filter takes
+	Stages            int       // Chained filters, 1 for fixed-size filters
+	LastAdjustment    time.Time // Last growth of the filter, zero if it cannot grow
+}
+
+// keysAdder — membership filters that add many keys in one round-trip, used by the bootstrap
+type keysAdder interface {
+	AddKeys(keys []string)
+}
+
+// repairableFilter — membership filters kept outside the process, which can fail to add keys
+// or lose them. membership retries their pending keys and bootstraps them again after a loss.
+type repairableFilter interface {
+	// retryPending adds the keys a failed round-trip left pending
+	retryPending()
+	// losses counts the times keys were lost and the losses a bootstrap made up for
+	losses() (lost, repaired uint64)
+	// repair records that a bootstrap added every key again after lost losses
+	repair(lost uint64) error
+}
+
+// filterRepairInterval is how often pending keys of a repairableFilter are retried
+const filterRepairInterval = time.Second
+
+// membership wraps the MembershipFilter of a cache: it bootstraps the filter from the database,
+// reports every key as present while the filter is incomplete and exports its gauges. A filter
+// that lost keys is bootstrapped again from scanner, when the database implements KeyScanner.
+// A CountingBloomFilter counts every Add, so membership adds each key once and removes only
+// the keys it added.
+type membership struct {
+	filter     MembershipFilter
+	debug      bool
+	scanner    KeyScanner // Nil when the database cannot list its keys
+	metrics    *filterMetrics
+	bootstrap  *bloomBootstrap
+	background storage.Background
+	mu         sync.Mutex
+	counted    map[string]struct{} // Keys added to a counting filter, nil for other filters
+	countLimit int                 // Bound of counted, the key capacity of the filter
+}
+
+func newMembership(filter MembershipFilter, scanner KeyScanner, debug bool, metrics MetricsConfig) *membership {
+	m := &membership{
+		filter:    filter,
+		debug:     debug,
+		scanner:   scanner,
+		metrics:   newFilterMetrics(metrics),
+		bootstrap: newBloomBootstrap(),
+	}
+	if counting, ok := filter.(*CountingBloomFilter); ok {
+		stats := counting.Stats()
+		m.counted = make(map[string]struct{})
+		m.countLimit = int(filterKeyCapacity(stats.Capacity, stats.HashFunctions))
+	}
+	m.metrics.ready.Set(1)
+	m.Start(context.Background())
+	return m
+}
+
+// Start runs the periodic metrics updates and repairs, newMembership calls it
+func (m *membership) Start(ctx context.Context) {
+	m.background.Start(ctx, m.metricsUpdater, m.repairer)
+}
+
+// Stop stops the metrics updates and repairs
+func (m *membership) Stop() {
+	m.background.Stop()
+}
+
+// Add adds the key to the filter. A counting filter counts each key once while fewer keys than
+// its capacity are counted. Past the capacity new keys are added on every call and can no longer
+// be removed: their counters only grow, so they stay present like in a plain Bloom filter.
+func (m *membership) Add(key string) {
+	if m.counted == nil {
+		m.filter.Add(key)
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.counted[key]; ok {
+		return
+	}
+	if len(m.counted) < m.countLimit {
+		m.counted[key] = struct{}{}
+	}
+	m.filter.Add(key)
+}
+
+func (m *membership) Exists(key string) bool {
+	if m.bootstrap.permissive.Load() {
+		return true // Keys of the database may not be loaded yet
+	}
+	return m.filter.Exists(key)
+}
+
+// Remove forgets a key that is no longer stored in the database. It reports whether the key
+// was removed, only keys counted by a counting filter are.
+func (m *membership) Remove(key string) bool {
+	if m.counted == nil {
+		m.filter.Remove(key)
+		return false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.counted[key]; !ok {
+		return false
+	}
+	delete(m.counted, key)
+	m.filter.Remove(key)
+	return true
+}
+
+func (m *membership) metricsUpdater(ctx context.Context) {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			m.updateMetrics()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (m *membership) repairer(ctx context.Context) {
+	filter, ok := m.filter.(repairableFilter)
+	if !ok {
+		return
+	}
+	ticker := time.NewTicker(filterRepairInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			m.repair(ctx, filter)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// repair retries the pending keys of the filter and bootstraps it again once it lost keys.
+// Without a scanner the filter stays permissive.
+func (m *membership) repair(ctx context.Context, filter repairableFilter) {
+	filter.retryPending()
+	if lost, repaired := filter.losses(); lost == repaired || m.scanner == nil {
+		return
+	}
+	if err := m.beginBootstrap(); err != nil {
+		return // The running bootstrap is retried once it finished
+	}
+	log.Printf("[BLOOM] The filter lost keys, bootstrapping it again")
+	if err := m.runBootstrap(ctx, m.scanner); err != nil {
+		log.Printf("[BLOOM] Bootstrap of the filter failed, it excludes no key until it is retried: %v", err)
+	}
+}
+
+func (m *membership) updateMetrics() {
+	stats := m.filter.Stats()
+	m.metrics.update(stats)
+	if m.debug {
+		log.Printf("[BLOOM] Metrics updated - "+
+			"Stages: %d, Capacity: %d, Count: %d, Hashes: %d, FPR: %.6f, Load: %.4f",
+			stats.Stages, stats.Capacity, stats.Count, stats.HashFunctions,
+			stats.FalsePositiveRate, stats.LoadFactor)
+	}
+}
+
+// forgetExpired removes a key the database no longer holds from the filter. Keys the filter
+// never counted are false positives and stay, removing them would decrement other keys.
+func (c *MultiTierCache) forgetExpired(ctx context.Context, key string) {
+	c.ttlManager.RemoveTTL(key)
+	if !c.filter.Remove(key) {
+		return
+	}
+	// A write committed after the database was read must keep its key
+	if _, err := c.db.Get(ctx, key); err == nil {
+		c.filter.Add(key)
+	}
+}
+
+// filterWritten adds keys persisted by the write queue back to the filter, they may have been
+// removed as expired while their write was pending
+func (c *MultiTierCache) filterWritten(tasks []WriteTask) {
+	for _, task := range tasks {
+		c.filter.Add(task.Key)
+	}
+}
@@ -2,6 +2,7 @@ package multi_tier_caching
 
 import (
 	"context"
+	"sync"
 	"time"
 
 	"github.com/arturmon/multi-tier-caching/storage"
@@ -9,11 +10,18 @@ import (
 
 type MemoryCache struct {
 	storage *storage.RistrettoCache
+	tagsMu  sync.Mutex
+	tags    map[string]map[string]struct{} // Local reverse index: tag -> keys
+	keyTags map[string]map[string]struct{} // key -> tags, prunes the reverse index when the key leaves
 }
 
 // NewMemoryCache initializes a new database cache
 func NewMemoryCache(ram *storage.RistrettoCache) *MemoryCache {
-	return &MemoryCache{storage: ram}
+	return &MemoryCache{
+		storage: ram,
+		tags:    make(map[string]map[string]struct{}),
+		keyTags: make(map[string]map[string]struct{}),
+	}
 }
 
 // Get retrieves the value from the database cache
@@ -34,15 +42,83 @@ func (m *MemoryCache) Set(ctx context.Context, key string, value string, ttl tim
 	return nil
 }
 
-// Delete removes a value from the database cache
+// Delete removes a value from the database cache and from the tag index
 func (m *MemoryCache) Delete(ctx context.Context, key string) {
 	m.storage.Delete(ctx, key)
+	m.tagsMu.Lock()
+	defer m.tagsMu.Unlock()
+	m.untag(key)
+}
+
+// AddTags records the key in the local reverse index of every tag.
+// Ristretto expires the key itself, so the ttl is not tracked here.
+func (m *MemoryCache) AddTags(ctx context.Context, key string, tags []string, ttl time.Duration) error {
+	m.tagsMu.Lock()
+	defer m.tagsMu.Unlock()
+	if m.keyTags[key] == nil {
+		m.keyTags[key] = make(map[string]struct{})
+	}
+	for _, tag := range tags {
+		if m.tags[tag] == nil {
+			m.tags[tag] = make(map[string]struct{})
+		}
+		m.tags[tag][key] = struct{}{}
+		m.keyTags[key][tag] = struct{}{}
+	}
+	return nil
+}
+
+// TagKeys returns the keys of the tag, keys evicted or expired from Ristretto are pruned from the index
+func (m *MemoryCache) TagKeys(ctx context.Context, tag string) ([]string, error) {
+	m.tagsMu.Lock()
+	defer m.tagsMu.Unlock()
+	keys := make([]string, 0, len(m.tags[tag]))
+	for key := range m.tags[tag] {
+		if !m.storage.Has(key) {
+			m.untag(key)
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func (m *MemoryCache) RemoveTag(ctx context.Context, tag string) error {
+	m.tagsMu.Lock()
+	defer m.tagsMu.Unlock()
+	for key := range m.tags[tag] {
+		delete(m.keyTags[key], tag)
+		if len(m.keyTags[key]) == 0 {
+			delete(m.keyTags, key)
+		}
+	}
+	delete(m.tags, tag)
+	return nil
+}
+
+// untag removes the key from every tag it belongs to, tagsMu must be held
+func (m *MemoryCache) untag(key string) {
+	for tag := range m.keyTags[key] {
+		delete(m.tags[tag], key)
+		if len(m.tags[tag]) == 0 {
+			delete(m.tags, tag)
+		}
+	}
+	delete(m.keyTags, key)
 }
 
 func (m *MemoryCache) CheckHealth(ctx context.Context) error {
 	return m.storage.CheckHealth(ctx)
 }
 
+func (m *MemoryCache) Start(ctx context.Context) {
+	m.storage.Start(ctx)
+}
+
+func (m *MemoryCache) Stop() {
+	m.storage.Stop()
+}
+
 func (m *MemoryCache) String() string {
 	return "Ristretto"
 }
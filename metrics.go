@@ -0,0 +1,7 @@
+package multi_tier_caching
+
+import "github.com/arturmon/multi-tier-caching/storage"
+
+// MetricsConfig selects where the collectors of a cache are registered and how they are named.
+// Caches sharing a registry need distinct namespaces or labels, see storage.MetricsConfig.
+type MetricsConfig = storage.MetricsConfig
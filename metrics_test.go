@@ -0,0 +1,108 @@
+package multi_tier_caching
+
+import (
+	"context"
+	"testing"
+
+	"github.com/arturmon/multi-tier-caching/storage"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newMetricsTestCache(t *testing.T, reg prometheus.Registerer, name string) *MultiTierCache {
+	ctx := context.Background()
+	ram, err := storage.NewRistrettoCache(ctx, 10, storage.WithRegisterer(reg), storage.WithNamespace("app"),
+		storage.WithConstLabels(prometheus.Labels{"cache_name": name}))
+	require.NoError(t, err)
+	cache := NewMultiTierCache(ctx, MultiTierCacheConfig{
+		Layers:           []LayerInfo{NewLayerInfo(NewMemoryCache(ram))},
+		DB:               newBatchTestStore("db", map[string]string{"key": "value"}),
+		Thresholds:       []int{0},
+		BloomSize:        1000,
+		BloomHashes:      3,
+		Registerer:       reg,
+		MetricsNamespace: "app",
+		MetricsLabels:    prometheus.Labels{"cache_name": name},
+	})
+	cache.filter.Add("key")
+	t.Cleanup(func() {
+		cache.Close()
+		ram.Close()
+	})
+	return cache
+}
+
+func TestMultiTierCache_MetricsRegistry(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("separate registries", func(t *testing.T) {
+		regA, regB := prometheus.NewRegistry(), prometheus.NewRegistry()
+		cacheA := newMetricsTestCache(t, regA, "users")
+		newMetricsTestCache(t, regB, "users")
+
+		_, err := cacheA.Get(ctx, "key")
+		require.NoError(t, err)
+
+		assert.Equal(t, 1.0, testutil.ToFloat64(cacheA.analytics.cacheHits.WithLabelValues("database")))
+		families, err := regB.Gather()
+		require.NoError(t, err)
+		for _, family := range families {
+			if family.GetName() == "app_cache_hits_total" {
+				assert.Empty(t, family.GetMetric(), "hits of one cache must not show up in another registry")
+			}
+		}
+	})
+
+	t.Run("shared registry", func(t *testing.T) {
+		reg := prometheus.NewRegistry()
+		users := newMetricsTestCache(t, reg, "users")
+		orders := newMetricsTestCache(t, reg, "orders")
+		duplicate := MultiTierCacheConfig{
+			Layers:           []LayerInfo{NewLayerInfo(newBatchTestStore("memory", nil))},
+			DB:               newBatchTestStore("db", nil),
+			Thresholds:       []int{0},
+			BloomSize:        1000,
+			BloomHashes:      3,
+			Registerer:       reg,
+			MetricsNamespace: "app",
+			MetricsLabels:    prometheus.Labels{"cache_name": "orders"},
+		}
+		_, err := OpenMultiTierCache(ctx, duplicate)
+		var already prometheus.AlreadyRegisteredError
+		assert.ErrorAs(t, err, &already, "Caches with identical labels would overwrite each other's gauges")
+		assert.Panics(t, func() { NewMultiTierCache(ctx, duplicate) })
+		_, err = storage.NewRistrettoCache(ctx, 10, storage.WithRegisterer(reg), storage.WithNamespace("app"),
+			storage.WithConstLabels(prometheus.Labels{"cache_name": "orders"}))
+		assert.ErrorAs(t, err, &already)
+
+		// A failed constructor unregisters the collectors it registered before the duplicate
+		queueLength := MetricsConfig{Registerer: reg, Namespace: "app", ConstLabels: prometheus.Labels{"cache_name": "billing"}}.
+			Gauge(prometheus.GaugeOpts{Name: "write_queue_length", Help: "Current number of tasks in the write queue"})
+		duplicate.MetricsLabels = prometheus.Labels{"cache_name": "billing"}
+		_, err = OpenMultiTierCache(ctx, duplicate)
+		assert.ErrorAs(t, err, &already)
+		reg.Unregister(queueLength)
+		billing, err := OpenMultiTierCache(ctx, duplicate)
+		require.NoError(t, err)
+		billing.Close()
+
+		_, err = users.Get(ctx, "key")
+		require.NoError(t, err)
+
+		assert.Equal(t, 1.0, testutil.ToFloat64(users.analytics.cacheHits.WithLabelValues("database")))
+		assert.Equal(t, 0.0, testutil.ToFloat64(orders.analytics.cacheHits.WithLabelValues("database")))
+
+		families, err := reg.Gather()
+		require.NoError(t, err)
+		names := make(map[string]bool)
+		for _, family := range families {
+			names[family.GetName()] = true
+		}
+		assert.True(t, names["app_cache_hits_total"])
+		assert.True(t, names["app_write_queue_length"])
+		assert.True(t, names["app_bloom_filter_capacity"])
+		assert.True(t, names["app_ristretto_entries"])
+	})
+}
@@ -6,6 +6,8 @@ import (
 	"log"
 	"sync"
 	"time"
+
+	"github.com/arturmon/multi-tier-caching/storage"
 )
 
 type MigrationManager struct {
@@ -16,6 +18,7 @@ type MigrationManager struct {
 	thresholds     []int
 	db             Database
 	debug          bool
+	background     storage.Background
 }
 
 func NewMigrationManager(
@@ -38,9 +41,16 @@ func NewMigrationManager(
 }
 
 func (m *MigrationManager) Start(ctx context.Context) {
-	for i := 0; i < 5; i++ { // 5 workers
-		go m.scheduleDynamic(ctx)
+	workers := make([]func(ctx context.Context), 5) // 5 workers
+	for i := range workers {
+		workers[i] = m.scheduleDynamic
 	}
+	m.background.Start(ctx, workers...)
+}
+
+// Stop stops the migration workers and waits for the running migrations
+func (m *MigrationManager) Stop() {
+	m.background.Stop()
 }
 
 func (m *MigrationManager) scheduleDynamic(ctx context.Context) {
@@ -83,12 +93,9 @@ func (m *MigrationManager) processMigrations(ctx context.Context) {
 		m.analytics.migrationTime.Observe(time.Since(start).Seconds())
 	}()
 
-	frequencyMap := m.analytics.GetFrequencyPerMinute()
-	if frequencyMap == nil {
-		return
-	}
-
-	for key, freq := range frequencyMap {
+	// Only the hottest keys are candidates, at most as many as the queue can take
+	for _, stat := range m.analytics.TopK(cap(m.migrationQueue)) {
+		key, freq := stat.Key, m.analytics.perWindow(stat.Rate)
 		currentLayer := m.getCurrentLayer(ctx, key)
 		if currentLayer == 0 {
 			continue // Skip keys in hot layer
@@ -199,7 +206,7 @@ func (m *MigrationManager) selectTargetLayerIndex(freq int) int {
 func (m *MigrationManager) findKeyValue(ctx context.Context, key string) (string, error) {
 	for i := len(m.layers) - 1; i >= 0; i-- {
 		value, err := m.layers[i].Layer.Get(ctx, key)
-		if err == nil {
+		if err == nil && value != negativeEntry {
 			return value, nil
 		}
 	}
@@ -1,6 +1,20 @@
 package multi_tier_caching
 
+import "time"
+
 type LayerInfo struct {
 	Layer CacheLayer
 	Name  string
 }
+
+// Entry — cached value with the metadata used by the stale-while-revalidate mode
+type Entry struct {
+	Value      string
+	StoredAt   time.Time
+	SoftExpiry time.Time // Zero when the entry never becomes stale before its hard TTL
+}
+
+// IsStale reports whether the soft TTL of the entry has passed
+func (e Entry) IsStale(now time.Time) bool {
+	return !e.SoftExpiry.IsZero() && now.After(e.SoftExpiry)
+}
@@ -0,0 +1,73 @@
+package multi_tier_caching
+
+import (
+	"context"
+	"log"
+)
+
+// negativeEntry is the tombstone value cached for keys confirmed absent from the database
+const negativeEntry = "\x00mtc:negative\x00"
+
+// lookupStatus is the outcome of a search through the cache layers
+type lookupStatus int
+
+const (
+	lookupMiss lookupStatus = iota
+	lookupHit
+	lookupNegative // A tombstone was found, the key is known to be absent
+)
+
+// storeNegative caches a tombstone for the key in the hot layers. A key with a queued or in-flight
+// write-behind task is skipped: the database does not hold it yet, but the cache must keep
+// serving the written value.
+func (c *MultiTierCache) storeNegative(ctx context.Context, key string) {
+	if c.negativeTTL <= 0 || c.writeQueue.Pending(key) {
+		return
+	}
+	for _, layer := range c.hotLayers() {
+		if err := layer.Layer.Set(ctx, key, negativeEntry, c.negativeTTL); err != nil {
+			log.Printf("[CACHE] Failed to store negative entry for key=%s in %v: %v", key, layer.Name, err)
+			continue
+		}
+	}
+	// A write queued meanwhile may have been overwritten by the tombstone
+	if c.writeQueue.Pending(key) {
+		c.clearNegative(ctx, key)
+		return
+	}
+	c.analytics.LogNegativeStore()
+	if c.debug {
+		log.Printf("[CACHE] Stored negative entry for key=%s, TTL=%v", key, c.negativeTTL)
+	}
+}
+
+// clearNegative removes tombstones of the key from the layers that hold one
+func (c *MultiTierCache) clearNegative(ctx context.Context, key string) {
+	if c.negativeTTL <= 0 {
+		return
+	}
+	for _, layer := range c.hotLayers() {
+		if value, err := layer.Layer.Get(ctx, key); err == nil && value == negativeEntry {
+			layer.Layer.Delete(ctx, key)
+			if c.debug {
+				log.Printf("[CACHE] Cleared negative entry for key=%s in %v", key, layer.Name)
+			}
+		}
+	}
+}
+
+// hotLayers returns the layers that may hold tombstones. Layers backed by the database
+// (see BatchWriter and KeyScanner) are left out, a tombstone there would be persisted.
+func (c *MultiTierCache) hotLayers() []LayerInfo {
+	layers := make([]LayerInfo, 0, len(c.layers))
+	for _, layer := range c.layers {
+		if _, ok := layer.Layer.(BatchWriter); ok {
+			continue
+		}
+		if _, ok := layer.Layer.(KeyScanner); ok {
+			continue
+		}
+		layers = append(layers, layer)
+	}
+	return layers
+}
@@ -0,0 +1,110 @@
+package multi_tier_caching
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMultiTierCache_NegativeCache(t *testing.T) {
+	ctx := context.Background()
+	layer := newBatchTestStore("memory", nil)
+	db := newBatchTestStore("db", nil)
+	cache := newTestCache(t, MultiTierCacheConfig{
+		Layers:      []LayerInfo{NewLayerInfo(layer)},
+		DB:          db,
+		Thresholds:  []int{0},
+		NegativeTTL: time.Minute,
+	})
+	cache.filter.Add("absent") // A Bloom false positive sends the key to the database
+
+	for i := 0; i < 3; i++ {
+		_, err := cache.Get(ctx, "absent")
+		assert.ErrorIs(t, err, ErrCacheMiss)
+	}
+	assert.Equal(t, 1, db.single, "Only the first miss may reach the database")
+
+	result, err := cache.MGet(ctx, []string{"absent"})
+	assert.NoError(t, err)
+	assert.Empty(t, result)
+	assert.Equal(t, 0, db.mgets, "Tombstones must stop batch reads before the database")
+
+	// An explicit Set replaces the tombstone
+	require.NoError(t, cache.Set(ctx, "absent", "value"))
+	value, err := cache.Get(ctx, "absent")
+	assert.NoError(t, err)
+	assert.Equal(t, "value", value)
+
+	// Delete clears a tombstone as well
+	cache.storeNegative(ctx, "deleted")
+	require.NoError(t, cache.Delete(ctx, "deleted"))
+	_, ok := layer.value("deleted")
+	assert.False(t, ok)
+}
+
+// persistedLayer is a layer backed by the database, like DatabaseCache
+type persistedLayer struct {
+	*batchTestStore
+}
+
+func (l *persistedLayer) WriteBatch(ctx context.Context, tasks []WriteTask) error { return nil }
+
+func TestMultiTierCache_NegativeCache_Placement(t *testing.T) {
+	ctx := context.Background()
+	hot := newBatchTestStore("hot", nil)
+	persisted := &persistedLayer{newBatchTestStore("persisted", nil)}
+	db := newBatchTestStore("db", nil)
+	cache := newTestCache(t, MultiTierCacheConfig{
+		Layers:      []LayerInfo{NewLayerInfo(hot), NewLayerInfo(persisted)},
+		DB:          db,
+		Thresholds:  []int{0, 0},
+		NegativeTTL: time.Minute,
+	})
+	cache.filter.Add("absent")
+
+	_, err := cache.Get(ctx, "absent")
+	assert.ErrorIs(t, err, ErrCacheMiss)
+	value, ok := hot.value("absent")
+	assert.True(t, ok)
+	assert.Equal(t, negativeEntry, value)
+	_, ok = persisted.value("absent")
+	assert.False(t, ok, "A layer backed by the database must not store tombstones")
+
+	// A negative hit is a miss as well
+	_, err = cache.Get(ctx, "absent")
+	assert.ErrorIs(t, err, ErrCacheMiss)
+	assert.Equal(t, 2.0, testutil.ToFloat64(cache.analytics.cacheMisses))
+	assert.Equal(t, 1.0, testutil.ToFloat64(cache.analytics.negativeHits))
+}
+
+func TestMultiTierCache_NegativeCache_PendingWrite(t *testing.T) {
+	ctx := context.Background()
+	layer := newBatchTestStore("memory", nil)
+	db := newBatchTestStore("db", nil)
+	cache := newTestCache(t, MultiTierCacheConfig{
+		Layers:      []LayerInfo{NewLayerInfo(layer)},
+		DB:          db,
+		Thresholds:  []int{0},
+		NegativeTTL: time.Minute,
+
+		WriteMaxLinger: time.Hour, // Keep the write pending
+		WriteBatchSize: 100,
+	})
+
+	// The layer lost the value before the write-behind reached the database
+	require.NoError(t, cache.Set(ctx, "key1", "value1"))
+	layer.Delete(ctx, "key1")
+	_, err := cache.Get(ctx, "key1")
+	assert.ErrorIs(t, err, ErrCacheMiss)
+	_, ok := layer.value("key1")
+	assert.False(t, ok, "A key with a pending write must not get a tombstone")
+
+	require.NoError(t, cache.writeQueue.Flush(ctx))
+	value, err := cache.Get(ctx, "key1")
+	assert.NoError(t, err)
+	assert.Equal(t, "value1", value)
+}
@@ -17,11 +17,11 @@ func NewRedisCache(client *storage.RedisStorage) *RedisCache {
 
 // Get now takes a context and returns a string.
 func (r *RedisCache) Get(ctx context.Context, key string) (string, error) {
-	value, err := r.storage.Get(ctx, key)
+	entry, err := r.GetEntry(ctx, key)
 	if err != nil {
 		return "", err
 	}
-	return value, err
+	return entry.Value, nil
 }
 
 // Set now takes a context.
@@ -30,15 +30,68 @@ func (r *RedisCache) Set(ctx context.Context, key string, value string, ttl time
 	return nil
 }
 
+// MGet reads several keys in one round-trip
+func (r *RedisCache) MGet(ctx context.Context, keys []string) (map[string]string, error) {
+	values, err := r.storage.MGet(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range values {
+		values[key] = decodeEntry(value).Value
+	}
+	return values, nil
+}
+
+// MSet writes several keys in one round-trip
+func (r *RedisCache) MSet(ctx context.Context, items map[string]string, ttl time.Duration) error {
+	return r.storage.MSet(ctx, items, ttl)
+}
+
+// GetEntry reads the value with the metadata stored next to it
+func (r *RedisCache) GetEntry(ctx context.Context, key string) (Entry, error) {
+	value, err := r.storage.Get(ctx, key)
+	if err != nil {
+		return Entry{}, err
+	}
+	return decodeEntry(value), nil
+}
+
+// SetEntry stores the value with its metadata in a single string
+func (r *RedisCache) SetEntry(ctx context.Context, key string, entry Entry, ttl time.Duration) error {
+	r.storage.Set(ctx, key, encodeEntry(entry), ttl)
+	return nil
+}
+
 // Delete now takes a context.
 func (r *RedisCache) Delete(ctx context.Context, key string) {
 	r.storage.Delete(ctx, key)
 }
 
+// AddTags stores tag membership in Redis sets
+func (r *RedisCache) AddTags(ctx context.Context, key string, tags []string, ttl time.Duration) error {
+	return r.storage.AddTags(ctx, key, tags, ttl)
+}
+
+func (r *RedisCache) TagKeys(ctx context.Context, tag string) ([]string, error) {
+	return r.storage.TagKeys(ctx, tag)
+}
+
+func (r *RedisCache) RemoveTag(ctx context.Context, tag string) error {
+	return r.storage.RemoveTag(ctx, tag)
+}
+
 func (r *RedisCache) CheckHealth(ctx context.Context) error {
 	return r.storage.CheckHealth(ctx)
 }
 
+func (r *RedisCache) Start(ctx context.Context) {
+	r.storage.Start(ctx)
+}
+
+func (r *RedisCache) Stop() {
+	r.storage.Stop()
+}
+
 func (r *RedisCache) String() string {
 	return "Redis"
 }
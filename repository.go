@@ -20,6 +20,81 @@ type Database interface {
 	Set(ctx context.Context, key, value string, ttl time.Duration) error
 }
 
+// BatchCacheLayer — optional interface for layers that read and write many keys in one round-trip
+type BatchCacheLayer interface {
+	MGet(ctx context.Context, keys []string) (map[string]string, error)
+	MSet(ctx context.Context, items map[string]string, ttl time.Duration) error
+}
+
+// BatchDatabase — optional interface for databases that read and write many keys in one round-trip
+type BatchDatabase interface {
+	MGet(ctx context.Context, keys []string) (map[string]string, error)
+	MSet(ctx context.Context, items map[string]string, ttl time.Duration) error
+}
+
+// BatchWriter — optional interface for databases that persist a batch of write-behind tasks,
+// each with its own TTL and entry metadata, in one round-trip
+type BatchWriter interface {
+	WriteBatch(ctx context.Context, tasks []WriteTask) error
+}
+
+// EntryStore — optional interface for layers and databases that persist entry metadata with the value
+type EntryStore interface {
+	GetEntry(ctx context.Context, key string) (Entry, error)
+	SetEntry(ctx context.Context, key string, entry Entry, ttl time.Duration) error
+}
+
+// TagIndex — optional interface for layers and databases that keep tag membership of keys
+type TagIndex interface {
+	AddTags(ctx context.Context, key string, tags []string, ttl time.Duration) error
+	TagKeys(ctx context.Context, tag string) ([]string, error)
+	RemoveTag(ctx context.Context, tag string) error
+}
+
+// DatabaseDeleter — optional interface for databases that can remove keys. The method is not
+// named Delete because a database may also serve as a CacheLayer.
+type DatabaseDeleter interface {
+	DeleteKey(ctx context.Context, key string) error
+}
+
+// KeyScanner — optional interface for databases that can enumerate their keys, used to fill
+// the Bloom filter on startup. fn is called once per key, an error from fn stops the scan.
+type KeyScanner interface {
+	ScanKeys(ctx context.Context, fn func(key string) error) error
+}
+
+// IncrementalKeyScanner — optional interface for databases that can enumerate the keys stored
+// after a time, used to catch up a Bloom filter restored from a snapshot
+type IncrementalKeyScanner interface {
+	ScanKeysSince(ctx context.Context, since time.Time, fn func(key string) error) error
+}
+
+// MembershipFilter — set of the keys stored in the database. Get skips the database for keys the
+// filter reports absent, so Exists may report absent keys as present but never the opposite.
+// Remove forgets a key deleted from the database, filters that cannot forget keys ignore it.
+type MembershipFilter interface {
+	Add(key string)
+	Exists(key string) bool
+	Remove(key string)
+	Stats() FilterStats
+}
+
+// BitStore — bitmap shared by several processes, e.g. a Redis string (storage.RedisStorage).
+// SharedBloomFilter keeps its bits there.
+type BitStore interface {
+	SetBits(ctx context.Context, key string, offsets []uint64) error
+	GetBits(ctx context.Context, key string, offsets []uint64) ([]bool, error)
+	CountBits(ctx context.Context, key string) (uint64, error)
+}
+
+// Lifecycle — components running background goroutines. Start is a no-op while the component
+// runs, Stop blocks until its goroutines have exited. MultiTierCache.Close stops every layer and
+// database implementing it, unless KeepComponentsOpen is set.
+type Lifecycle interface {
+	Start(ctx context.Context)
+	Stop()
+}
+
 type HealthChecker interface {
 	CheckHealth(ctx context.Context) error
 }
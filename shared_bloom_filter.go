@@ -0,0 +1,206 @@
+package multi_tier_caching
+
+import (
+	"context"
+	"log"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// sharedFilterTimeout bounds every round-trip to the bit store
+	sharedFilterTimeout = time.Second
+	// maxSharedPending bounds the keys kept while the bit store fails
+	maxSharedPending = 10000
+	// sharedLogInterval is the least time between two logged failures of a filter
+	sharedLogInterval = 10 * time.Second
+)
+
+// SharedBloomFilter is a Bloom filter whose bits live in a BitStore, so every instance using the
+// same store and key shares one view of the keys stored in the database. Adding or testing a key
+// is one pipelined round-trip. While the store fails Exists reports every key as present and
+// added keys are kept until their bits can be set, so no stored key is reported absent.
+// The bitmap has a fixed size of bits and cannot forget keys.
+//
+// A sentinel bit past the filter bits marks the bitmap as whole. When it is missing (the key was
+// evicted or flushed) or added keys overflow the pending ones, the filter has lost keys: Exists
+// reports every key as present until a bootstrap added the keys of the database again, which the
+// cache runs in the background when its database implements KeyScanner.
+type SharedBloomFilter struct {
+	store      BitStore
+	key        string
+	m          uint64
+	k          uint
+	debug      bool
+	mu         sync.Mutex
+	pending    map[string]struct{} // Keys whose bits could not be set yet
+	lost       atomic.Uint64       // Times keys were lost
+	repaired   atomic.Uint64       // Losses a bootstrap made up for
+	lastLog    atomic.Int64        // Unix nanoseconds of the last logged failure
+	suppressed atomic.Int64        // Failures not logged since then
+}
+
+// NewSharedBloomFilter creates a filter of size bits stored under key and hashFuncs hash functions.
+// Every instance sharing the filter must use the same size and number of hash functions,
+// a Redis bitmap takes at most 2^32 bits, the sentinel bit included.
+func NewSharedBloomFilter(store BitStore, key string, size uint, hashFuncs uint, debug bool) *SharedBloomFilter {
+	f := &SharedBloomFilter{
+		store:   store,
+		key:     key,
+		m:       uint64(max(size, 1)),
+		k:       max(hashFuncs, 1),
+		debug:   debug,
+		pending: make(map[string]struct{}),
+	}
+	// A missing sentinel is taken for a lost bitmap once the store is reachable
+	if err := f.setSentinel(); err != nil {
+		f.logf("[BLOOM] Failed to mark the shared filter %s: %v", f.key, err)
+	}
+	return f
+}
+
+func (f *SharedBloomFilter) Add(key string) {
+	f.AddKeys([]string{key})
+}
+
+// AddKeys sets the bits of several keys in one round-trip, together with the keys a failed
+// round-trip left pending. Keys past maxSharedPending are lost until the next bootstrap.
+func (f *SharedBloomFilter) AddKeys(keys []string) {
+	f.mu.Lock()
+	batch := make([]string, 0, len(keys)+len(f.pending))
+	batch = append(batch, keys...)
+	for key := range f.pending {
+		batch = append(batch, key)
+	}
+	f.mu.Unlock()
+	if len(batch) == 0 {
+		return
+	}
+
+	offsets := make([]uint64, 0, len(batch)*int(f.k))
+	for _, key := range batch {
+		offsets = append(offsets, hashLocations(key, f.k, f.m)...)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), sharedFilterTimeout)
+	defer cancel()
+	err := f.store.SetBits(ctx, f.key, offsets)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err == nil {
+		for _, key := range batch {
+			delete(f.pending, key)
+		}
+		if f.debug {
+			log.Printf("[BLOOM] Added %d keys to the shared filter %s", len(batch), f.key)
+		}
+		return
+	}
+	dropped := 0
+	for _, key := range keys {
+		if len(f.pending) < maxSharedPending {
+			f.pending[key] = struct{}{}
+		} else {
+			dropped++
+		}
+	}
+	if dropped > 0 {
+		f.lost.Add(1)
+	}
+	f.logf("[BLOOM] Failed to add %d keys to the shared filter %s, %d pending, %d lost: %v",
+		len(batch), f.key, len(f.pending), dropped, err)
+}
+
+func (f *SharedBloomFilter) Exists(key string) bool {
+	if f.lost.Load() != f.repaired.Load() {
+		return true // Waiting for a bootstrap
+	}
+	f.mu.Lock()
+	_, pending := f.pending[key]
+	f.mu.Unlock()
+	if pending {
+		return true
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sharedFilterTimeout)
+	defer cancel()
+	offsets := append(hashLocations(key, f.k, f.m), f.m)
+	bits, err := f.store.GetBits(ctx, f.key, offsets)
+	if err != nil {
+		f.logf("[BLOOM] Failed to check key=%s in the shared filter %s, not excluding it: %v", key, f.key, err)
+		return true
+	}
+	if !bits[len(bits)-1] {
+		f.lost.Add(1)
+		f.logf("[BLOOM] The bitmap of the shared filter %s is missing, no key is excluded until it is bootstrapped", f.key)
+		return true
+	}
+	exists := true
+	for _, bit := range bits {
+		exists = exists && bit
+	}
+	if f.debug {
+		log.Printf("[BLOOM] Check key=%s, exists=%v", key, exists)
+	}
+	return exists
+}
+
+// Remove does nothing, the bits of a key are shared with other keys and instances
+func (f *SharedBloomFilter) Remove(string) {}
+
+// Stats estimates the keys of every instance from the number of set bits
+func (f *SharedBloomFilter) Stats() FilterStats {
+	stats := FilterStats{Capacity: uint(f.m), HashFunctions: f.k, Stages: 1}
+	ctx, cancel := context.WithTimeout(context.Background(), sharedFilterTimeout)
+	defer cancel()
+	set, err := f.store.CountBits(ctx, f.key)
+	if err != nil {
+		f.logf("[BLOOM] Failed to count the bits of the shared filter %s: %v", f.key, err)
+		return stats
+	}
+	// Expected number of keys setting that many of m bits with k hash functions
+	fill := min(float64(set)/float64(f.m), 1-1/float64(f.m))
+	stats.Count = uint(-float64(f.m) / float64(f.k) * math.Log1p(-fill))
+	stats.FalsePositiveRate = estimateFalsePositiveRate(f.k, uint(f.m), stats.Count)
+	stats.LoadFactor = float64(stats.Count) / float64(filterKeyCapacity(uint(f.m), f.k))
+	return stats
+}
+
+func (f *SharedBloomFilter) retryPending() {
+	f.AddKeys(nil)
+}
+
+func (f *SharedBloomFilter) losses() (lost, repaired uint64) {
+	return f.lost.Load(), f.repaired.Load()
+}
+
+func (f *SharedBloomFilter) repair(lost uint64) error {
+	if err := f.setSentinel(); err != nil {
+		return err
+	}
+	f.repaired.Store(lost)
+	return nil
+}
+
+func (f *SharedBloomFilter) setSentinel() error {
+	ctx, cancel := context.WithTimeout(context.Background(), sharedFilterTimeout)
+	defer cancel()
+	return f.store.SetBits(ctx, f.key, []uint64{f.m})
+}
+
+// logf logs a failure at most once per sharedLogInterval, with the number of failures skipped
+func (f *SharedBloomFilter) logf(format string, args ...any) {
+	now := time.Now().UnixNano()
+	last := f.lastLog.Load()
+	if last != 0 && now-last < int64(sharedLogInterval) || !f.lastLog.CompareAndSwap(last, now) {
+		f.suppressed.Add(1)
+		return
+	}
+	if skipped := f.suppressed.Swap(0); skipped > 0 {
+		format += " (%d similar failures not logged)"
+		args = append(args, skipped)
+	}
+	log.Printf(format, args...)
+}
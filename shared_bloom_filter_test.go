@@ -0,0 +1,208 @@
+package multi_tier_caching
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryBitStore is a BitStore kept in memory, err fails every call while set
+type memoryBitStore struct {
+	mu    sync.Mutex
+	bits  map[string]map[uint64]bool
+	err   error
+	calls int
+}
+
+func newMemoryBitStore() *memoryBitStore {
+	return &memoryBitStore{bits: make(map[string]map[uint64]bool)}
+}
+
+func (s *memoryBitStore) SetBits(ctx context.Context, key string, offsets []uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls++
+	if s.err != nil {
+		return s.err
+	}
+	if s.bits[key] == nil {
+		s.bits[key] = make(map[uint64]bool)
+	}
+	for _, offset := range offsets {
+		s.bits[key][offset] = true
+	}
+	return nil
+}
+
+func (s *memoryBitStore) GetBits(ctx context.Context, key string, offsets []uint64) ([]bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls++
+	if s.err != nil {
+		return nil, s.err
+	}
+	bits := make([]bool, len(offsets))
+	for i, offset := range offsets {
+		bits[i] = s.bits[key][offset]
+	}
+	return bits, nil
+}
+
+func (s *memoryBitStore) CountBits(ctx context.Context, key string) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return 0, s.err
+	}
+	return uint64(len(s.bits[key])), nil
+}
+
+func (s *memoryBitStore) fail(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err
+}
+
+// flush drops every bitmap, like an eviction or FLUSHDB
+func (s *memoryBitStore) flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	clear(s.bits)
+}
+
+func TestSharedBloomFilter(t *testing.T) {
+	store := newMemoryBitStore()
+	podA := NewSharedBloomFilter(store, "bloom", 100000, 5, false)
+	podB := NewSharedBloomFilter(store, "bloom", 100000, 5, false)
+	other := NewSharedBloomFilter(store, "other", 100000, 5, false)
+
+	podA.Add("key1")
+	assert.True(t, podB.Exists("key1"), "a key added by one instance exists for every instance")
+	assert.False(t, podB.Exists("key2"))
+	assert.False(t, other.Exists("key1"), "filters under other keys are separate")
+
+	calls := store.calls
+	podA.AddKeys([]string{"key2", "key3", "key4"})
+	podB.Exists("key4")
+	assert.Equal(t, calls+2, store.calls, "adding several keys and testing a key take one round-trip each")
+
+	for i := range 1000 {
+		podA.Add(fmt.Sprintf("key-%d", i))
+	}
+	stats := podB.Stats()
+	assert.InDelta(t, 1004, stats.Count, 20, "the count is estimated from the shared bits")
+	assert.Equal(t, uint(100000), stats.Capacity)
+}
+
+func TestSharedBloomFilter_StoreFailure(t *testing.T) {
+	store := newMemoryBitStore()
+	podA := NewSharedBloomFilter(store, "bloom", 100000, 5, false)
+	podB := NewSharedBloomFilter(store, "bloom", 100000, 5, false)
+
+	store.fail(errors.New("connection refused"))
+	podA.Add("key1")
+	assert.True(t, podA.Exists("absent"), "a failing store excludes no key")
+	assert.Zero(t, podA.Stats().Count)
+
+	store.fail(nil)
+	assert.True(t, podA.Exists("key1"), "a pending key exists for the instance that added it")
+	assert.False(t, podB.Exists("key1"))
+	podA.Add("key2")
+	assert.True(t, podB.Exists("key1"), "pending keys are written with the next successful Add")
+	assert.True(t, podB.Exists("key2"))
+	assert.Empty(t, podA.pending)
+
+	store.fail(errors.New("connection refused"))
+	podA.Add("key3")
+	store.fail(nil)
+	podA.retryPending()
+	assert.True(t, podB.Exists("key3"), "pending keys are retried without waiting for an Add")
+	assert.Empty(t, podA.pending)
+}
+
+func TestSharedBloomFilter_PendingOverflow(t *testing.T) {
+	store := newMemoryBitStore()
+	filter := NewSharedBloomFilter(store, "bloom", 100000, 5, false)
+
+	store.fail(errors.New("connection refused"))
+	keys := make([]string, maxSharedPending+1)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key-%d", i)
+	}
+	filter.AddKeys(keys)
+	assert.Len(t, filter.pending, maxSharedPending)
+
+	store.fail(nil)
+	filter.retryPending()
+	assert.True(t, filter.Exists("absent"), "a filter that dropped keys excludes no key")
+	lost, repaired := filter.losses()
+	assert.NotEqual(t, lost, repaired, "dropped keys wait for a bootstrap")
+}
+
+func TestSharedBloomFilter_MissingBitmap(t *testing.T) {
+	ctx := context.Background()
+	store := newMemoryBitStore()
+	release := make(chan struct{})
+	close(release)
+	db := &scanTestStore{batchTestStore: newBatchTestStore("db", map[string]string{"key1": "value1"}), release: release}
+	filter := NewSharedBloomFilter(store, "bloom", 100000, 5, false)
+	m := newMembership(filter, db, false, MetricsConfig{Registerer: prometheus.NewRegistry()})
+	t.Cleanup(m.Stop)
+
+	m.Add("key1")
+	assert.False(t, m.Exists("absent"))
+
+	store.flush()
+	assert.True(t, m.Exists("absent"), "a missing bitmap excludes no key")
+	assert.True(t, m.Exists("absent"), "the filter stays permissive once the bitmap was missing")
+
+	m.repair(ctx, filter)
+	assert.True(t, m.Exists("key1"), "the bootstrap adds the keys of the database again")
+	assert.False(t, m.Exists("absent"), "the filter excludes keys again once bootstrapped")
+	assert.NoError(t, m.BootstrapProgress().Err)
+}
+
+func TestSharedBloomFilter_LogsFailuresOnce(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	store := newMemoryBitStore()
+	filter := NewSharedBloomFilter(store, "bloom", 100000, 5, false)
+	store.fail(errors.New("connection refused"))
+	for range 100 {
+		assert.True(t, filter.Exists("key1"))
+	}
+	assert.Equal(t, 1, strings.Count(buf.String(), "connection refused"), "failures are logged once per interval")
+}
+
+func TestMultiTierCache_SharedFilter(t *testing.T) {
+	ctx := context.Background()
+	store := newMemoryBitStore()
+	db := newBatchTestStore("db", nil)
+	newPod := func() *MultiTierCache {
+		return newTestCache(t, MultiTierCacheConfig{
+			Layers:      []LayerInfo{NewLayerInfo(newBatchTestStore("memory", nil))},
+			DB:          db,
+			Thresholds:  []int{0},
+			Filter:      NewSharedBloomFilter(store, "bloom", 100000, 5, false),
+			WritePolicy: WriteThrough,
+		})
+	}
+	podA, podB := newPod(), newPod()
+
+	require.NoError(t, podA.Set(ctx, "key1", "value1"))
+	value, err := podB.Get(ctx, "key1")
+	require.NoError(t, err, "a key set on one pod is read from the database on another")
+	assert.Equal(t, "value1", value)
+}
@@ -0,0 +1,151 @@
+package multi_tier_caching
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// staleWhileRevalidate reports whether entries get a soft TTL
+func (c *MultiTierCache) staleWhileRevalidate() bool {
+	return c.softTTLRatio > 0
+}
+
+// newEntry builds an entry whose soft expiry is a fraction of the hard TTL
+func (c *MultiTierCache) newEntry(value string, ttl time.Duration) Entry {
+	now := time.Now()
+	entry := Entry{Value: value, StoredAt: now}
+	if c.staleWhileRevalidate() {
+		entry.SoftExpiry = now.Add(time.Duration(float64(ttl) * c.softTTLRatio))
+	}
+	return entry
+}
+
+// newWriteTask builds a write-behind task carrying the entry metadata
+func (c *MultiTierCache) newWriteTask(key, value string, ttl time.Duration) WriteTask {
+	entry := c.newEntry(value, ttl)
+	return WriteTask{Key: key, Value: value, TTL: ttl, StoredAt: entry.StoredAt, SoftExpiry: entry.SoftExpiry}
+}
+
+// persist writes a task to the database, keeping the entry metadata when the database can store it
+func (c *MultiTierCache) persist(ctx context.Context, task WriteTask) error {
+	if store, ok := c.db.(EntryStore); ok && !task.SoftExpiry.IsZero() {
+		return store.SetEntry(ctx, task.Key, Entry{Value: task.Value, StoredAt: task.StoredAt, SoftExpiry: task.SoftExpiry}, task.TTL)
+	}
+	return c.db.Set(ctx, task.Key, task.Value, task.TTL)
+}
+
+// writeLayer stores the value in a layer as a fresh entry (see writeLayerEntry)
+func (c *MultiTierCache) writeLayer(ctx context.Context, layer CacheLayer, key, value string, ttl time.Duration) error {
+	if !c.staleWhileRevalidate() {
+		return layer.Set(ctx, key, value, ttl)
+	}
+	return c.writeLayerEntry(ctx, layer, key, c.newEntry(value, ttl), ttl)
+}
+
+// writeLayerEntry stores the entry in a layer. In stale-while-revalidate mode the entry metadata is
+// persisted by layers implementing EntryStore, for the others it is kept by the TTLManager.
+func (c *MultiTierCache) writeLayerEntry(ctx context.Context, layer CacheLayer, key string, entry Entry, ttl time.Duration) error {
+	if !c.staleWhileRevalidate() {
+		return layer.Set(ctx, key, entry.Value, ttl)
+	}
+	if store, ok := layer.(EntryStore); ok {
+		return store.SetEntry(ctx, key, entry, ttl)
+	}
+	if err := layer.Set(ctx, key, entry.Value, ttl); err != nil {
+		return err
+	}
+	if !entry.SoftExpiry.IsZero() {
+		c.ttlManager.SetSoftExpiry(key, entry.SoftExpiry, ttl)
+	}
+	return nil
+}
+
+// readLayer reads the key from a layer together with its metadata
+func (c *MultiTierCache) readLayer(ctx context.Context, layer CacheLayer, key string) (Entry, error) {
+	if !c.staleWhileRevalidate() {
+		value, err := layer.Get(ctx, key)
+		return Entry{Value: value}, err
+	}
+	if store, ok := layer.(EntryStore); ok {
+		return store.GetEntry(ctx, key)
+	}
+	value, err := layer.Get(ctx, key)
+	if err != nil {
+		return Entry{}, err
+	}
+	return Entry{Value: value, SoftExpiry: c.ttlManager.GetSoftExpiry(key)}, nil
+}
+
+// revalidate refreshes a stale key in the background, only one refresh per key runs at a time.
+// The refreshed value replaces the key in layerIndex and in every other layer holding it.
+// A nil load falls back to the configured Loader and then to the database.
+func (c *MultiTierCache) revalidate(ctx context.Context, key string, layerIndex int, load func(ctx context.Context) (string, time.Duration, error)) {
+	if _, running := c.refreshing.LoadOrStore(key, struct{}{}); running {
+		return
+	}
+	fromDB := false
+	if load == nil {
+		load, fromDB = c.refreshLoader(key)
+	}
+
+	spawned := c.spawn(func() {
+		defer c.refreshing.Delete(key)
+		ctx := context.WithoutCancel(ctx)
+
+		value, ttl, err := load(ctx)
+		if err != nil {
+			log.Printf("[CACHE] Revalidation of key=%s failed: %v", key, err)
+			return
+		}
+		if ttl <= 0 {
+			ttl = time.Duration(c.ttlManager.calculateAdaptiveTTL(c.analytics.GetFrequency(key))) * time.Second
+		}
+
+		written := 0
+		for i, layer := range c.layers {
+			if i != layerIndex {
+				if _, err := c.readLayer(ctx, layer.Layer, key); err != nil {
+					continue
+				}
+			}
+			if err := c.writeLayer(ctx, layer.Layer, key, value, ttl); err != nil {
+				log.Printf("[CACHE] Revalidation of key=%s in %v failed: %v", key, layer.Name, err)
+				continue
+			}
+			written++
+		}
+		if written == 0 {
+			return
+		}
+		c.ttlManager.AdjustTTL(key, int64(ttl/time.Second))
+		if !fromDB {
+			if err = c.writeQueue.Enqueue(ctx, c.newWriteTask(key, value, ttl)); err != nil {
+				log.Printf("[CACHE] Failed to queue revalidated key=%s: %v", key, err)
+			}
+		}
+		if c.debug {
+			log.Printf("[CACHE] Revalidated key=%s in %d layers, TTL=%v", key, written, ttl)
+		}
+	})
+	if !spawned {
+		c.refreshing.Delete(key)
+	}
+}
+
+// refreshLoader returns the source used to refresh stale keys and whether it is the database
+func (c *MultiTierCache) refreshLoader(key string) (func(ctx context.Context) (string, time.Duration, error), bool) {
+	if c.loader != nil {
+		return func(ctx context.Context) (string, time.Duration, error) {
+			return c.loader(ctx, key)
+		}, false
+	}
+	return func(ctx context.Context) (string, time.Duration, error) {
+		value, err := c.db.Get(ctx, key)
+		if err != nil {
+			return "", 0, fmt.Errorf("database: %w", err)
+		}
+		return value, 0, nil
+	}, true
+}
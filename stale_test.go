@@ -0,0 +1,125 @@
+package multi_tier_caching
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEntryEncoding(t *testing.T) {
+	entry := Entry{
+		Value:      "value\x00with separators",
+		StoredAt:   time.Unix(0, 1700000000000000000),
+		SoftExpiry: time.Unix(0, 1700000060000000000),
+	}
+	decoded := decodeEntry(encodeEntry(entry))
+	assert.Equal(t, entry.Value, decoded.Value)
+	assert.True(t, entry.StoredAt.Equal(decoded.StoredAt))
+	assert.True(t, entry.SoftExpiry.Equal(decoded.SoftExpiry))
+
+	assert.Equal(t, Entry{Value: "plain"}, decodeEntry("plain"), "Values without metadata are returned as is")
+}
+
+func TestMultiTierCache_StaleWhileRevalidate(t *testing.T) {
+	ctx := context.Background()
+	layer := newBatchTestStore("memory", nil)
+
+	var loads int32
+	release := make(chan struct{})
+	cache := newTestCache(t, MultiTierCacheConfig{
+		Layers:       []LayerInfo{NewLayerInfo(layer)},
+		DB:           newBatchTestStore("db", nil),
+		Thresholds:   []int{0},
+		SoftTTLRatio: 0.5,
+		Loader: func(ctx context.Context, key string) (string, time.Duration, error) {
+			atomic.AddInt32(&loads, 1)
+			<-release
+			return "fresh", time.Minute, nil
+		},
+	})
+
+	require.NoError(t, cache.Set(ctx, "key1", "old"))
+	assert.False(t, cache.ttlManager.GetSoftExpiry("key1").IsZero(), "Soft expiry must be tracked for plain layers")
+
+	// Fresh value: no refresh
+	value, err := cache.Get(ctx, "key1")
+	assert.NoError(t, err)
+	assert.Equal(t, "old", value)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&loads))
+
+	// Past the soft TTL the stale value is served and a single refresh runs
+	cache.ttlManager.SetSoftExpiry("key1", time.Now().Add(-time.Second), time.Minute)
+	for i := 0; i < 10; i++ {
+		value, err = cache.Get(ctx, "key1")
+		assert.NoError(t, err)
+		assert.Equal(t, "old", value)
+	}
+	close(release)
+
+	assert.Eventually(t, func() bool {
+		value, _ := layer.value("key1")
+		return value == "fresh"
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&loads), "Concurrent stale reads must trigger one refresh")
+}
+
+// entryTestStore keeps the entry metadata encoded in the value, like the Redis layer
+type entryTestStore struct {
+	*batchTestStore
+}
+
+func (s *entryTestStore) GetEntry(ctx context.Context, key string) (Entry, error) {
+	value, err := s.Get(ctx, key)
+	if err != nil {
+		return Entry{}, err
+	}
+	return decodeEntry(value), nil
+}
+
+func (s *entryTestStore) SetEntry(ctx context.Context, key string, entry Entry, ttl time.Duration) error {
+	return s.Set(ctx, key, encodeEntry(entry), ttl)
+}
+
+func TestMultiTierCache_StaleBackfill(t *testing.T) {
+	ctx := context.Background()
+	stale := Entry{Value: "old", StoredAt: time.Now().Add(-time.Hour), SoftExpiry: time.Now().Add(-time.Minute)}
+	hot := &entryTestStore{newBatchTestStore("hot", nil)}
+	warm := &entryTestStore{newBatchTestStore("warm", map[string]string{"key1": encodeEntry(stale)})}
+
+	release := make(chan struct{})
+	cache := newTestCache(t, MultiTierCacheConfig{
+		Layers:       []LayerInfo{NewLayerInfo(hot), NewLayerInfo(warm)},
+		DB:           newBatchTestStore("db", nil),
+		Thresholds:   []int{0, 0},
+		SoftTTLRatio: 0.5,
+		Backfill:     BackfillAlways,
+		Loader: func(ctx context.Context, key string) (string, time.Duration, error) {
+			<-release
+			return "fresh", time.Minute, nil
+		},
+	})
+
+	value, err := cache.Get(ctx, "key1")
+	require.NoError(t, err)
+	assert.Equal(t, "old", value)
+
+	// The copy in the hot layer keeps the metadata, it is not served as fresh
+	copied, err := hot.GetEntry(ctx, "key1")
+	require.NoError(t, err)
+	assert.True(t, copied.SoftExpiry.Equal(stale.SoftExpiry))
+	assert.True(t, copied.IsStale(time.Now()))
+
+	// The refresh replaces the value in every layer holding the key
+	close(release)
+	cache.tasks.Wait()
+	for _, layer := range []*entryTestStore{hot, warm} {
+		entry, err := layer.GetEntry(ctx, "key1")
+		require.NoError(t, err)
+		assert.Equal(t, "fresh", entry.Value, layer.name)
+		assert.False(t, entry.IsStale(time.Now()), layer.name)
+	}
+}
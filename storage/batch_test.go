@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The batch paths run against real servers, the tests are skipped unless these are set
+const (
+	postgresTestDSNEnv = "MTC_TEST_POSTGRES_DSN"
+	redisTestAddrEnv   = "MTC_TEST_REDIS_ADDR"
+)
+
+func TestDatabaseStorage_Batch(t *testing.T) {
+	dsn := os.Getenv(postgresTestDSNEnv)
+	if dsn == "" {
+		t.Skipf("%s is not set", postgresTestDSNEnv)
+	}
+	ctx := context.Background()
+	db, err := NewDatabaseStorage(dsn, false, WithRegisterer(prometheus.NewRegistry()))
+	require.NoError(t, err)
+	t.Cleanup(db.Close)
+	t.Cleanup(db.Stop)
+
+	prefix := fmt.Sprintf("batch-test-%d-", time.Now().UnixNano())
+	require.NoError(t, db.SetCacheMulti(ctx, map[string]string{prefix + "a": "1", prefix + "b": "2"}, time.Minute))
+	require.NoError(t, db.SetCacheBatch(ctx, []CacheWrite{
+		{Key: prefix + "c", Value: "old", TTL: time.Minute},
+		{Key: prefix + "c", Value: "3", TTL: time.Minute}, // The last row of a key wins
+		{Key: prefix + "expired", Value: "4", TTL: time.Microsecond},
+	}))
+	time.Sleep(10 * time.Millisecond)
+
+	values, err := db.GetCacheMulti(ctx, []string{prefix + "a", prefix + "b", prefix + "c", prefix + "expired", prefix + "missing"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{prefix + "a": "1", prefix + "b": "2", prefix + "c": "3"}, values,
+		"missing and expired keys are absent, not empty values")
+
+	_, err = db.GetCache(ctx, prefix+"missing")
+	assert.ErrorIs(t, err, ErrCacheMiss)
+	for _, key := range []string{"a", "b", "c", "expired"} {
+		assert.NoError(t, db.DeleteCache(ctx, prefix+key))
+	}
+}
+
+func TestRedisStorage_Batch(t *testing.T) {
+	addr := os.Getenv(redisTestAddrEnv)
+	if addr == "" {
+		t.Skipf("%s is not set", redisTestAddrEnv)
+	}
+	ctx := context.Background()
+	redis, err := NewRedisStorage(addr, "", 0, WithRegisterer(prometheus.NewRegistry()))
+	require.NoError(t, err)
+	t.Cleanup(redis.Stop)
+
+	prefix := fmt.Sprintf("batch-test-%d-", time.Now().UnixNano())
+	require.NoError(t, redis.MSet(ctx, map[string]string{prefix + "a": "1", prefix + "b": "2"}, time.Minute))
+	values, err := redis.MGet(ctx, []string{prefix + "a", prefix + "b", prefix + "missing"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{prefix + "a": "1", prefix + "b": "2"}, values)
+	redis.Delete(ctx, prefix+"a")
+	redis.Delete(ctx, prefix+"b")
+}
@@ -12,13 +12,14 @@ import (
 )
 
 type DatabaseStorage struct {
-	debug   bool
-	pool    *pgxpool.Pool
-	metrics *PostgresMetrics
+	debug      bool
+	pool       *pgxpool.Pool
+	metrics    *PostgresMetrics
+	background Background
 }
 
-// NewDatabaseStorage initializes a connection to PostgreSQL via pgx/v5
-func NewDatabaseStorage(dsn string, debug bool) (*DatabaseStorage, error) {
+// NewDatabaseStorage initializes a connection to PostgreSQL via pgx/v5, the options configure its metrics
+func NewDatabaseStorage(dsn string, debug bool, opts ...MetricsOption) (*DatabaseStorage, error) {
 	ctx := context.Background()
 	pool, err := pgxpool.New(ctx, dsn)
 	if err != nil {
@@ -38,12 +39,47 @@ func NewDatabaseStorage(dsn string, debug bool) (*DatabaseStorage, error) {
 		return nil, fmt.Errorf("failed to create cache table: %w", err)
 	}
 
+	// Entry metadata used by the stale-while-revalidate mode
+	_, err = pool.Exec(ctx, `
+		ALTER TABLE cache
+			ADD COLUMN IF NOT EXISTS stored_at TIMESTAMPTZ,
+			ADD COLUMN IF NOT EXISTS soft_expires_at TIMESTAMPTZ
+	`)
+	if err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("failed to add entry metadata columns: %w", err)
+	}
+
+	// Tag membership lives next to the cache table, keys may be tagged before the write-behind reaches the database
+	_, err = pool.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS cache_tags (
+			tag VARCHAR(255) NOT NULL,
+			key VARCHAR(255) NOT NULL,
+			PRIMARY KEY (tag, key)
+		)
+	`)
+	if err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("failed to create cache_tags table: %w", err)
+	}
+
+	// Tag membership expires with the key
+	_, err = pool.Exec(ctx, `
+		ALTER TABLE cache_tags
+			ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ
+	`)
+	if err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("failed to add cache_tags expiry column: %w", err)
+	}
+
 	// Create a function to clean expired cache entries
 	_, err = pool.Exec(ctx, `
 		CREATE OR REPLACE FUNCTION clean_expired_cache() 
 		RETURNS TRIGGER AS $$
 		BEGIN
 			DELETE FROM cache WHERE expires_at <= NOW();
+			DELETE FROM cache_tags WHERE expires_at <= NOW();
 			RETURN NEW;
 		END;
 		$$ LANGUAGE plpgsql;
@@ -74,11 +110,28 @@ func NewDatabaseStorage(dsn string, debug bool) (*DatabaseStorage, error) {
 	}
 
 	dbStorage := &DatabaseStorage{pool: pool, debug: debug}
-	dbStorage.initDatabaseMetrics(pool)
+	metrics, registration := newMetricsConfig(opts).Track()
+	dbStorage.initDatabaseMetrics(metrics)
+	if err := registration.Err(); err != nil {
+		registration.Undo()
+		pool.Close()
+		return nil, err
+	}
+	dbStorage.Start(ctx)
 	return dbStorage, nil
 }
 
-// GetCache gets a value from the cache by key
+// Start runs the pool metrics updates, NewDatabaseStorage calls it
+func (d *DatabaseStorage) Start(ctx context.Context) {
+	d.background.Start(ctx, d.updateDatabaseMetrics)
+}
+
+// Stop stops the metrics updates and waits for them
+func (d *DatabaseStorage) Stop() {
+	d.background.Stop()
+}
+
+// GetCache gets a value from the cache by key, ErrCacheMiss is returned for missing keys
 func (d *DatabaseStorage) GetCache(ctx context.Context, key string) (string, error) {
 	if d.debug {
 		log.Printf("[DB CACHE] Getting key=%s", key)
@@ -95,7 +148,7 @@ func (d *DatabaseStorage) GetCache(ctx context.Context, key string) (string, err
 
 	if errors.Is(err, pgx.ErrNoRows) {
 		d.metrics.Misses.Inc() // Increasing misses
-		return "", nil
+		return "", ErrCacheMiss
 	} else if err != nil {
 		return "", err
 	}
@@ -111,10 +164,10 @@ func (d *DatabaseStorage) SetCache(ctx context.Context, key string, value string
 	}
 	start := time.Now()
 	_, err := d.pool.Exec(ctx, `
-		INSERT INTO cache (key, value, expires_at) 
-		VALUES ($1, $2, NOW() + $3::interval) 
+		INSERT INTO cache (key, value, expires_at, stored_at) 
+		VALUES ($1, $2, NOW() + $3::interval, NOW()) 
 		ON CONFLICT (key) 
-		DO UPDATE SET value = $2, expires_at = NOW() + $3::interval`,
+		DO UPDATE SET value = $2, expires_at = NOW() + $3::interval, stored_at = NOW(), soft_expires_at = NULL`,
 		key, value, ttl.String())
 	d.metrics.QueryCount.Inc()
 	d.metrics.QueryDuration.Observe(time.Since(start).Seconds())
@@ -122,6 +175,228 @@ func (d *DatabaseStorage) SetCache(ctx context.Context, key string, value string
 	return err
 }
 
+// CacheEntry — cached value with the metadata persisted next to it
+type CacheEntry struct {
+	Value         string
+	StoredAt      time.Time
+	SoftExpiresAt time.Time // Zero when the entry has no soft expiry
+}
+
+// GetCacheEntry gets a value together with its metadata, ErrCacheMiss is returned for missing keys
+func (d *DatabaseStorage) GetCacheEntry(ctx context.Context, key string) (CacheEntry, error) {
+	var (
+		entry         CacheEntry
+		storedAt      *time.Time
+		softExpiresAt *time.Time
+	)
+	start := time.Now()
+	err := d.pool.QueryRow(ctx,
+		"SELECT value, stored_at, soft_expires_at FROM cache WHERE key = $1 AND expires_at > NOW()",
+		key,
+	).Scan(&entry.Value, &storedAt, &softExpiresAt)
+
+	d.metrics.QueryCount.Inc()
+	d.metrics.QueryDuration.Observe(time.Since(start).Seconds())
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		d.metrics.Misses.Inc()
+		return CacheEntry{}, ErrCacheMiss
+	} else if err != nil {
+		return CacheEntry{}, err
+	}
+	if storedAt != nil {
+		entry.StoredAt = *storedAt
+	}
+	if softExpiresAt != nil {
+		entry.SoftExpiresAt = *softExpiresAt
+	}
+	d.metrics.Hits.WithLabelValues("PostgreSQL").Inc()
+	return entry, nil
+}
+
+// SetCacheEntry sets a value together with its metadata and a hard TTL
+func (d *DatabaseStorage) SetCacheEntry(ctx context.Context, key string, entry CacheEntry, ttl time.Duration) error {
+	if d.debug {
+		log.Printf("[DB CACHE] Setting entry key=%s with TTL: %v, soft expiry: %v", key, ttl, entry.SoftExpiresAt)
+	}
+	var softExpiresAt *time.Time
+	if !entry.SoftExpiresAt.IsZero() {
+		softExpiresAt = &entry.SoftExpiresAt
+	}
+	start := time.Now()
+	_, err := d.pool.Exec(ctx, `
+		INSERT INTO cache (key, value, expires_at, stored_at, soft_expires_at) 
+		VALUES ($1, $2, NOW() + $3::interval, $4, $5) 
+		ON CONFLICT (key) 
+		DO UPDATE SET value = $2, expires_at = NOW() + $3::interval, stored_at = $4, soft_expires_at = $5`,
+		key, entry.Value, ttl.String(), entry.StoredAt, softExpiresAt)
+	d.metrics.QueryCount.Inc()
+	d.metrics.QueryDuration.Observe(time.Since(start).Seconds())
+	d.metrics.Writes.Inc()
+	return err
+}
+
+// GetCacheMulti gets several values in one query, missing or expired keys are absent from the result
+func (d *DatabaseStorage) GetCacheMulti(ctx context.Context, keys []string) (map[string]string, error) {
+	if d.debug {
+		log.Printf("[DB CACHE] Getting %d keys", len(keys))
+	}
+	start := time.Now()
+	defer func() { d.metrics.QueryDuration.Observe(time.Since(start).Seconds()) }()
+	rows, err := d.pool.Query(ctx,
+		"SELECT key, value FROM cache WHERE key = ANY($1) AND expires_at > NOW()",
+		keys,
+	)
+	d.metrics.QueryCount.Inc()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make(map[string]string, len(keys))
+	for rows.Next() {
+		var key, value string
+		if err = rows.Scan(&key, &value); err != nil {
+			return nil, err
+		}
+		result[key] = value
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	d.metrics.Hits.WithLabelValues("PostgreSQL").Add(float64(len(result)))
+	d.metrics.Misses.Add(float64(len(keys) - len(result)))
+	return result, nil
+}
+
+// ScanCacheKeys streams the keys of the unexpired rows to fn without loading them all in memory,
+// the scan stops at the first error returned by fn
+func (d *DatabaseStorage) ScanCacheKeys(ctx context.Context, fn func(key string) error) error {
+	if d.debug {
+		log.Printf("[DB CACHE] Scanning keys")
+	}
+	return d.scanCacheKeys(ctx, fn, "SELECT key FROM cache WHERE expires_at IS NULL OR expires_at > NOW()")
+}
+
+// ScanCacheKeysSince streams the keys of the unexpired rows stored after since, like ScanCacheKeys
+func (d *DatabaseStorage) ScanCacheKeysSince(ctx context.Context, since time.Time, fn func(key string) error) error {
+	if d.debug {
+		log.Printf("[DB CACHE] Scanning keys stored since %v", since)
+	}
+	return d.scanCacheKeys(ctx, fn,
+		"SELECT key FROM cache WHERE stored_at > $1 AND (expires_at IS NULL OR expires_at > NOW())", since)
+}
+
+func (d *DatabaseStorage) scanCacheKeys(ctx context.Context, fn func(key string) error, query string, args ...any) error {
+	rows, err := d.pool.Query(ctx, query, args...)
+	d.metrics.QueryCount.Inc()
+	if err != nil {
+		return fmt.Errorf("scan cache keys: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var key string
+		if err = rows.Scan(&key); err != nil {
+			return fmt.Errorf("scan cache keys: %w", err)
+		}
+		if err = fn(key); err != nil {
+			return err
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("scan cache keys: %w", err)
+	}
+	return nil
+}
+
+// SetCacheMulti sets several values with the same TTL in one batch
+func (d *DatabaseStorage) SetCacheMulti(ctx context.Context, items map[string]string, ttl time.Duration) error {
+	if d.debug {
+		log.Printf("[DB CACHE] Setting %d keys with TTL: %v", len(items), ttl)
+	}
+	start := time.Now()
+	batch := &pgx.Batch{}
+	for key, value := range items {
+		batch.Queue(`
+		INSERT INTO cache (key, value, expires_at, stored_at) 
+		VALUES ($1, $2, NOW() + $3::interval, NOW()) 
+		ON CONFLICT (key) 
+		DO UPDATE SET value = $2, expires_at = NOW() + $3::interval, stored_at = NOW(), soft_expires_at = NULL`,
+			key, value, ttl.String())
+	}
+	err := d.pool.SendBatch(ctx, batch).Close()
+	d.metrics.QueryCount.Inc()
+	d.metrics.QueryDuration.Observe(time.Since(start).Seconds())
+	if err != nil {
+		return err
+	}
+	d.metrics.Writes.Add(float64(len(items))) // Increment writes
+	return nil
+}
+
+// CacheWrite — one row of a SetCacheBatch call, every row has its own TTL and metadata
+type CacheWrite struct {
+	Key           string
+	Value         string
+	TTL           time.Duration
+	StoredAt      time.Time // NOW() when zero
+	SoftExpiresAt time.Time // Zero when the entry has no soft expiry
+}
+
+// SetCacheBatch upserts all rows with a single multi-row statement. When a key occurs
+// more than once the last row wins, as a statement cannot update the same row twice.
+func (d *DatabaseStorage) SetCacheBatch(ctx context.Context, writes []CacheWrite) error {
+	if len(writes) == 0 {
+		return nil
+	}
+	if d.debug {
+		log.Printf("[DB CACHE] Upserting batch of %d keys", len(writes))
+	}
+	index := make(map[string]int, len(writes))
+	var (
+		keys          []string
+		values        []string
+		ttls          []int64
+		storedAt      []*time.Time
+		softExpiresAt []*time.Time
+	)
+	for _, write := range writes {
+		var stored, soft *time.Time
+		if !write.StoredAt.IsZero() {
+			stored = &write.StoredAt
+		}
+		if !write.SoftExpiresAt.IsZero() {
+			soft = &write.SoftExpiresAt
+		}
+		if i, ok := index[write.Key]; ok {
+			values[i], ttls[i], storedAt[i], softExpiresAt[i] = write.Value, write.TTL.Microseconds(), stored, soft
+			continue
+		}
+		index[write.Key] = len(keys)
+		keys = append(keys, write.Key)
+		values = append(values, write.Value)
+		ttls = append(ttls, write.TTL.Microseconds())
+		storedAt = append(storedAt, stored)
+		softExpiresAt = append(softExpiresAt, soft)
+	}
+
+	start := time.Now()
+	_, err := d.pool.Exec(ctx, `
+		INSERT INTO cache (key, value, expires_at, stored_at, soft_expires_at)
+		SELECT k, v, NOW() + t * INTERVAL '1 microsecond', COALESCE(s, NOW()), se
+		FROM unnest($1::text[], $2::text[], $3::bigint[], $4::timestamptz[], $5::timestamptz[]) AS u(k, v, t, s, se)
+		ON CONFLICT (key)
+		DO UPDATE SET value = EXCLUDED.value, expires_at = EXCLUDED.expires_at,
+			stored_at = EXCLUDED.stored_at, soft_expires_at = EXCLUDED.soft_expires_at`,
+		keys, values, ttls, storedAt, softExpiresAt)
+	d.metrics.QueryCount.Inc()
+	d.metrics.QueryDuration.Observe(time.Since(start).Seconds())
+	d.metrics.Writes.Add(float64(len(keys)))
+	return err
+}
+
 // DeleteCache removes a value from the cache by key
 func (d *DatabaseStorage) DeleteCache(ctx context.Context, key string) error {
 	start := time.Now()
@@ -131,8 +406,49 @@ func (d *DatabaseStorage) DeleteCache(ctx context.Context, key string) error {
 	return err
 }
 
-// Close closes the connection to the database
+// AddCacheTags ties the key to every tag until the TTL passes, an existing membership keeps the later expiry.
+// A ttl <= 0 leaves the membership without expiry, a membership without expiry keeps none.
+func (d *DatabaseStorage) AddCacheTags(ctx context.Context, key string, tags []string, ttl time.Duration) error {
+	start := time.Now()
+	_, err := d.pool.Exec(ctx, `
+		INSERT INTO cache_tags (tag, key, expires_at)
+		SELECT unnest($1::text[]), $2, CASE WHEN $3::interval > '0' THEN NOW() + $3::interval END
+		ON CONFLICT (tag, key)
+		DO UPDATE SET expires_at = CASE
+			WHEN cache_tags.expires_at IS NULL OR EXCLUDED.expires_at IS NULL THEN NULL
+			ELSE GREATEST(cache_tags.expires_at, EXCLUDED.expires_at)
+		END`,
+		tags, key, ttl.String())
+	d.metrics.QueryCount.Inc()
+	d.metrics.QueryDuration.Observe(time.Since(start).Seconds())
+	return err
+}
+
+// GetCacheTagKeys returns the keys tied to the tag
+func (d *DatabaseStorage) GetCacheTagKeys(ctx context.Context, tag string) ([]string, error) {
+	start := time.Now()
+	rows, err := d.pool.Query(ctx, "SELECT key FROM cache_tags WHERE tag = $1 AND (expires_at IS NULL OR expires_at > NOW())", tag)
+	d.metrics.QueryCount.Inc()
+	if err != nil {
+		return nil, err
+	}
+	keys, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	d.metrics.QueryDuration.Observe(time.Since(start).Seconds())
+	return keys, err
+}
+
+// DeleteCacheTag removes every membership of the tag
+func (d *DatabaseStorage) DeleteCacheTag(ctx context.Context, tag string) error {
+	start := time.Now()
+	_, err := d.pool.Exec(ctx, "DELETE FROM cache_tags WHERE tag = $1", tag)
+	d.metrics.QueryCount.Inc()
+	d.metrics.QueryDuration.Observe(time.Since(start).Seconds())
+	return err
+}
+
+// Close stops the metrics updates and closes the connection to the database
 func (d *DatabaseStorage) Close() {
+	d.Stop()
 	d.pool.Close()
 }
 
@@ -0,0 +1,44 @@
+package storage
+
+import (
+	"context"
+	"sync"
+)
+
+// Background runs the loops of a component between Start and Stop, components embed it to
+// implement Lifecycle
+type Background struct {
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// Start runs every loop with a context cancelled by Stop, it is a no-op while the loops run
+func (b *Background) Start(ctx context.Context, loops ...func(ctx context.Context)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.cancel != nil {
+		return
+	}
+	ctx, b.cancel = context.WithCancel(ctx)
+	for _, loop := range loops {
+		b.wg.Add(1)
+		go func() {
+			defer b.wg.Done()
+			loop(ctx)
+		}()
+	}
+}
+
+// Stop cancels the loops and waits until they return
+func (b *Background) Stop() {
+	b.mu.Lock()
+	cancel := b.cancel
+	b.cancel = nil
+	b.mu.Unlock()
+	if cancel == nil {
+		return
+	}
+	cancel()
+	b.wg.Wait()
+}
@@ -0,0 +1,144 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// MetricsConfig selects where the collectors of a cache or storage are registered and how they are named
+type MetricsConfig struct {
+	Registerer  prometheus.Registerer // prometheus.DefaultRegisterer when nil
+	Namespace   string                // Prefix of every metric name
+	ConstLabels prometheus.Labels     // Labels added to every metric, e.g. {"cache_name": "users"}
+	tracked     *Registration         // Set by Track
+}
+
+// Registration records the collectors registered through a tracked MetricsConfig and the first
+// registration that failed
+type Registration struct {
+	mu         sync.Mutex
+	registerer prometheus.Registerer
+	registered []prometheus.Collector
+	err        error
+}
+
+// Track returns the configuration recording its registrations in the returned Registration
+// instead of panicking when one fails, for constructors returning the error
+func (c MetricsConfig) Track() (MetricsConfig, *Registration) {
+	c.tracked = &Registration{registerer: c.registerer()}
+	return c, c.tracked
+}
+
+// Err returns the first failed registration, a prometheus.AlreadyRegisteredError when identical
+// metrics were registered before
+func (r *Registration) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// Undo unregisters the collectors registered so far, so a failed constructor can be retried
+func (r *Registration) Undo() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, collector := range r.registered {
+		r.registerer.Unregister(collector)
+	}
+	r.registered = nil
+}
+
+func (r *Registration) record(collector prometheus.Collector, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err == nil {
+		r.registered = append(r.registered, collector)
+	} else if r.err == nil {
+		r.err = err
+	}
+}
+
+// MetricsOption configures the metrics of a storage constructor
+type MetricsOption func(*MetricsConfig)
+
+// WithRegisterer registers the storage metrics with reg instead of the default registry
+func WithRegisterer(reg prometheus.Registerer) MetricsOption {
+	return func(c *MetricsConfig) {
+		c.Registerer = reg
+	}
+}
+
+// WithNamespace prefixes the storage metric names
+func WithNamespace(namespace string) MetricsOption {
+	return func(c *MetricsConfig) {
+		c.Namespace = namespace
+	}
+}
+
+// WithConstLabels adds constant labels to every storage metric
+func WithConstLabels(labels prometheus.Labels) MetricsOption {
+	return func(c *MetricsConfig) {
+		c.ConstLabels = labels
+	}
+}
+
+func newMetricsConfig(opts []MetricsOption) MetricsConfig {
+	var cfg MetricsConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg
+}
+
+// Counter registers a counter named after the configuration
+func (c MetricsConfig) Counter(opts prometheus.CounterOpts) prometheus.Counter {
+	opts.Namespace, opts.ConstLabels = c.Namespace, c.ConstLabels
+	return register(c, prometheus.NewCounter(opts))
+}
+
+// CounterVec registers a counter vector named after the configuration
+func (c MetricsConfig) CounterVec(opts prometheus.CounterOpts, labelNames []string) *prometheus.CounterVec {
+	opts.Namespace, opts.ConstLabels = c.Namespace, c.ConstLabels
+	return register(c, prometheus.NewCounterVec(opts, labelNames))
+}
+
+// Gauge registers a gauge named after the configuration
+func (c MetricsConfig) Gauge(opts prometheus.GaugeOpts) prometheus.Gauge {
+	opts.Namespace, opts.ConstLabels = c.Namespace, c.ConstLabels
+	return register(c, prometheus.NewGauge(opts))
+}
+
+// Histogram registers a histogram named after the configuration
+func (c MetricsConfig) Histogram(opts prometheus.HistogramOpts) prometheus.Histogram {
+	opts.Namespace, opts.ConstLabels = c.Namespace, c.ConstLabels
+	return register(c, prometheus.NewHistogram(opts))
+}
+
+func (c MetricsConfig) registerer() prometheus.Registerer {
+	if c.Registerer == nil {
+		return prometheus.DefaultRegisterer
+	}
+	return c.Registerer
+}
+
+// register registers the collector. A failure is recorded by a tracked configuration, otherwise
+// it panics like prometheus.MustRegister. Two components registering the same metric must not
+// share it, one would overwrite the gauges of the other, so they need distinct registries,
+// namespaces or labels.
+func register[T prometheus.Collector](c MetricsConfig, collector T) T {
+	err := c.registerer().Register(collector)
+	var already prometheus.AlreadyRegisteredError
+	if errors.As(err, &already) {
+		err = fmt.Errorf("%w: use a distinct Registerer, Namespace or ConstLabels per cache and storage", err)
+	}
+	if c.tracked != nil {
+		c.tracked.record(collector, err)
+		return collector
+	}
+	if err != nil {
+		panic(err)
+	}
+	return collector
+}
@@ -25,3 +25,8 @@ func (m *MockDatabaseStorage) CheckHealth(ctx context.Context) error {
 	args := m.Called(ctx)
 	return args.Error(0)
 }
+
+func (m *MockDatabaseStorage) DeleteKey(ctx context.Context, key string) error {
+	args := m.Called(ctx, key)
+	return args.Error(0)
+}
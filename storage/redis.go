@@ -9,12 +9,18 @@ import (
 	"github.com/redis/go-redis/v9"
 )
 
+// tagKeyPrefix prefixes the Redis sets holding the keys of a tag. Cache keys starting with a NUL
+// byte are reserved, so a set never shares its name with a cached key.
+const tagKeyPrefix = "\x00mtc:tag:"
+
 type RedisStorage struct {
-	client  *redis.Client
-	metrics *RedisMetrics
+	client     *redis.Client
+	metrics    *RedisMetrics
+	background Background
 }
 
-func NewRedisStorage(addr, password string, db int) (*RedisStorage, error) {
+// NewRedisStorage connects to Redis, the options configure the storage metrics
+func NewRedisStorage(addr, password string, db int, opts ...MetricsOption) (*RedisStorage, error) {
 	client := redis.NewClient(&redis.Options{
 		Addr:     addr,
 		Password: password,
@@ -30,10 +36,27 @@ func NewRedisStorage(addr, password string, db int) (*RedisStorage, error) {
 	}
 
 	warmStorage := &RedisStorage{client: client}
-	warmStorage.initRedisMetrics(client)
+	metrics, registration := newMetricsConfig(opts).Track()
+	warmStorage.initRedisMetrics(metrics)
+	if err := registration.Err(); err != nil {
+		registration.Undo()
+		client.Close()
+		return nil, err
+	}
+	warmStorage.Start(context.Background())
 	return warmStorage, nil
 }
 
+// Start runs the pool metrics updates, NewRedisStorage calls it
+func (r *RedisStorage) Start(ctx context.Context) {
+	r.background.Start(ctx, r.updateRedisMetrics)
+}
+
+// Stop stops the metrics updates and waits for them
+func (r *RedisStorage) Stop() {
+	r.background.Stop()
+}
+
 func (r *RedisStorage) Get(ctx context.Context, key string) (string, error) {
 	value, err := r.client.Get(ctx, key).Result()
 	if errors.Is(err, redis.Nil) {
@@ -51,10 +74,110 @@ func (r *RedisStorage) Set(ctx context.Context, key string, value interface{}, t
 	r.metrics.Writes.Inc() // metric
 }
 
+// MGet reads several keys with a single MGET, missing keys are absent from the result
+func (r *RedisStorage) MGet(ctx context.Context, keys []string) (map[string]string, error) {
+	values, err := r.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]string, len(keys))
+	for i, value := range values {
+		str, ok := value.(string)
+		if !ok {
+			r.metrics.Misses.Inc() // metric
+			continue
+		}
+		result[keys[i]] = str
+		r.metrics.Hits.WithLabelValues("redis").Inc() // metric
+	}
+	return result, nil
+}
+
+// MSet writes several keys with the same TTL in one pipeline
+func (r *RedisStorage) MSet(ctx context.Context, items map[string]string, ttl time.Duration) error {
+	pipe := r.client.Pipeline()
+	for key, value := range items {
+		pipe.Set(ctx, key, value, ttl)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+	r.metrics.Writes.Add(float64(len(items))) // metric
+	return nil
+}
+
 func (r *RedisStorage) Delete(ctx context.Context, key string) {
 	r.client.Del(ctx, key)
 }
 
+// addTagScript adds the member to the set and extends the TTL of the set to at least ARGV[2] milliseconds,
+// so the set lives as long as its longest-lived key. A set without expiry keeps none.
+var addTagScript = redis.NewScript(`
+redis.call('SADD', KEYS[1], ARGV[1])
+local pttl = redis.call('PTTL', KEYS[1])
+if pttl >= 0 and pttl < tonumber(ARGV[2]) then
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 1`)
+
+// AddTags adds the key to the set of every tag, the sets expire with the key. A ttl <= 0 removes their expiry.
+func (r *RedisStorage) AddTags(ctx context.Context, key string, tags []string, ttl time.Duration) error {
+	pipe := r.client.Pipeline()
+	for _, tag := range tags {
+		if ttl <= 0 {
+			pipe.SAdd(ctx, tagKeyPrefix+tag, key)
+			pipe.Persist(ctx, tagKeyPrefix+tag)
+			continue
+		}
+		addTagScript.Eval(ctx, pipe, []string{tagKeyPrefix + tag}, key, ttl.Milliseconds())
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// TagKeys returns the keys tied to the tag
+func (r *RedisStorage) TagKeys(ctx context.Context, tag string) ([]string, error) {
+	return r.client.SMembers(ctx, tagKeyPrefix+tag).Result()
+}
+
+// RemoveTag drops the set of the tag
+func (r *RedisStorage) RemoveTag(ctx context.Context, tag string) error {
+	return r.client.Del(ctx, tagKeyPrefix+tag).Err()
+}
+
+// SetBits sets the bits at the offsets of the bitmap stored at key in one pipeline
+func (r *RedisStorage) SetBits(ctx context.Context, key string, offsets []uint64) error {
+	pipe := r.client.Pipeline()
+	for _, offset := range offsets {
+		pipe.SetBit(ctx, key, int64(offset), 1)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// GetBits reads the bits at the offsets of the bitmap stored at key in one pipeline
+func (r *RedisStorage) GetBits(ctx context.Context, key string, offsets []uint64) ([]bool, error) {
+	pipe := r.client.Pipeline()
+	cmds := make([]*redis.IntCmd, len(offsets))
+	for i, offset := range offsets {
+		cmds[i] = pipe.GetBit(ctx, key, int64(offset))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+	bits := make([]bool, len(cmds))
+	for i, cmd := range cmds {
+		bits[i] = cmd.Val() == 1
+	}
+	return bits, nil
+}
+
+// CountBits returns the number of set bits of the bitmap stored at key
+func (r *RedisStorage) CountBits(ctx context.Context, key string) (uint64, error) {
+	count, err := r.client.BitCount(ctx, key, nil).Result()
+	return uint64(count), err
+}
+
 func (r *RedisStorage) CheckHealth(ctx context.Context) error {
 	return r.client.Ping(ctx).Err()
 }
@@ -13,8 +13,9 @@ var ErrCacheMiss = errors.New("cache miss")
 
 // RistrettoCache implements CacheLayer interface using Ristretto
 type RistrettoCache struct {
-	client  *ristretto.Cache
-	metrics *RistrettoMetrics
+	client     *ristretto.Cache
+	metrics    *RistrettoMetrics
+	background Background
 }
 
 const (
@@ -22,8 +23,8 @@ const (
 	buffersize = 64
 )
 
-// NewRistrettoCache initializes a new Ristretto cache
-func NewRistrettoCache(ctx context.Context, memoryLimitMB int64) (*RistrettoCache, error) {
+// NewRistrettoCache initializes a new Ristretto cache, the options configure its metrics
+func NewRistrettoCache(ctx context.Context, memoryLimitMB int64, opts ...MetricsOption) (*RistrettoCache, error) {
 	maxCost := int64(memoryLimitMB * 1024 * 1024)
 
 	cache, err := ristretto.NewCache(&ristretto.Config{
@@ -38,14 +39,34 @@ func NewRistrettoCache(ctx context.Context, memoryLimitMB int64) (*RistrettoCach
 	}
 
 	hotStorage := &RistrettoCache{client: cache}
-	hotStorage.initRistrettoMetrics(cache)
-
-	// Run background cache cleaning
-	go hotStorage.startCacheCleanup(ctx, cache)
+	metrics, registration := newMetricsConfig(opts).Track()
+	hotStorage.initRistrettoMetrics(metrics)
+	if err := registration.Err(); err != nil {
+		registration.Undo()
+		cache.Close()
+		return nil, err
+	}
+	hotStorage.Start(ctx)
 
 	return hotStorage, nil
 }
 
+// Start runs the background cache cleaning and metrics updates, NewRistrettoCache calls it
+func (r *RistrettoCache) Start(ctx context.Context) {
+	r.background.Start(ctx, r.startCacheCleanup, r.updateRistrettoMetrics)
+}
+
+// Stop stops the background goroutines and waits for them
+func (r *RistrettoCache) Stop() {
+	r.background.Stop()
+}
+
+// Close stops the background goroutines and releases the Ristretto cache, it cannot be used afterwards
+func (r *RistrettoCache) Close() {
+	r.Stop()
+	r.client.Close()
+}
+
 // Get retrieves a value from Ristretto
 func (r *RistrettoCache) Get(ctx context.Context, key string) (string, error) {
 	value, found := r.client.Get(key)
@@ -71,6 +92,12 @@ func (r *RistrettoCache) Set(ctx context.Context, key string, value string, ttl
 	return nil
 }
 
+// Has reports whether the key is stored and not expired, without counting a hit or a miss
+func (r *RistrettoCache) Has(key string) bool {
+	_, found := r.client.Get(key)
+	return found
+}
+
 // Delete removes a key from Ristretto
 func (r *RistrettoCache) Delete(ctx context.Context, key string) {
 	r.client.Del(key)
@@ -85,14 +112,14 @@ func (r *RistrettoCache) CheckHealth(ctx context.Context) error {
 }
 
 // startCacheCleanup Performs periodic cache cleaning.
-func (r *RistrettoCache) startCacheCleanup(ctx context.Context, cache *ristretto.Cache) {
+func (r *RistrettoCache) startCacheCleanup(ctx context.Context) {
 	ticker := time.NewTicker(1 * time.Minute) // Cleaning interval
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ticker.C:
-			cache.Clear()
+			r.client.Clear()
 			//log.Println("Cache clearing completed")
 		case <-ctx.Done():
 			//log.Println("Stop background cache cleaning")
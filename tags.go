@@ -0,0 +1,80 @@
+package multi_tier_caching
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"time"
+)
+
+// SetWithTags stores the value like Set and ties the key to every tag.
+// Membership is recorded in every layer and in the database that implement TagIndex
+// and expires with the key. A key the write plan skipped keeps its longer TTL, its tags too.
+func (c *MultiTierCache) SetWithTags(ctx context.Context, key, value string, tags ...string) error {
+	ttl, written, err := c.write(ctx, key, value, c.writePolicy)
+	if err != nil {
+		return err
+	}
+	if len(tags) == 0 {
+		return nil
+	}
+	if !written {
+		ttl = time.Duration(min(c.ttlManager.GetTTL(key), math.MaxInt64/int64(time.Second))) * time.Second
+	}
+	for _, index := range c.tagIndexes() {
+		if err := index.AddTags(ctx, key, tags, ttl); err != nil {
+			return fmt.Errorf("add tags for key=%s: %w", key, err)
+		}
+	}
+	if c.debug {
+		log.Printf("[CACHE] Tagged key=%s with %v", key, tags)
+	}
+	return nil
+}
+
+// InvalidateTag deletes every key tied to the tag from all layers (see Delete) and drops the tag.
+// Keys are collected from every tag index, so a key tagged only in Redis still leaves the in-process layers.
+func (c *MultiTierCache) InvalidateTag(ctx context.Context, tag string) error {
+	indexes := c.tagIndexes()
+	keys := make(map[string]struct{})
+	for _, index := range indexes {
+		tagKeys, err := index.TagKeys(ctx, tag)
+		if err != nil {
+			return fmt.Errorf("read tag=%s: %w", tag, err)
+		}
+		for _, key := range tagKeys {
+			keys[key] = struct{}{}
+		}
+	}
+
+	for key := range keys {
+		if err := c.Delete(ctx, key); err != nil {
+			return fmt.Errorf("invalidate tag=%s: %w", tag, err)
+		}
+	}
+
+	for _, index := range indexes {
+		if err := index.RemoveTag(ctx, tag); err != nil {
+			return fmt.Errorf("remove tag=%s: %w", tag, err)
+		}
+	}
+	if c.debug {
+		log.Printf("[CACHE] Invalidated tag=%s, %d keys", tag, len(keys))
+	}
+	return nil
+}
+
+// tagIndexes returns the layers and the database that keep tag membership
+func (c *MultiTierCache) tagIndexes() []TagIndex {
+	var indexes []TagIndex
+	for _, layer := range c.layers {
+		if index, ok := layer.Layer.(TagIndex); ok {
+			indexes = append(indexes, index)
+		}
+	}
+	if index, ok := c.db.(TagIndex); ok {
+		indexes = append(indexes, index)
+	}
+	return indexes
+}
@@ -0,0 +1,97 @@
+package multi_tier_caching
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/arturmon/multi-tier-caching/storage"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMultiTierCache_InvalidateTag(t *testing.T) {
+	ctx := context.Background()
+	ram, err := storage.NewRistrettoCache(ctx, 10, storage.WithRegisterer(prometheus.NewRegistry()))
+	require.NoError(t, err)
+	memory := NewMemoryCache(ram)
+	warm := newBatchTestStore("warm", nil)
+
+	cache := newTestCache(t, MultiTierCacheConfig{
+		Layers:     []LayerInfo{NewLayerInfo(memory), NewLayerInfo(warm)},
+		DB:         newBatchTestStore("db", nil),
+		Thresholds: []int{0, 0},
+	})
+
+	require.NoError(t, cache.SetWithTags(ctx, "user:42:profile", "p", "user:42"))
+	require.NoError(t, cache.SetWithTags(ctx, "user:42:orders", "o", "user:42", "orders"))
+	require.NoError(t, cache.SetWithTags(ctx, "user:7:profile", "p7", "user:7"))
+
+	require.NoError(t, cache.InvalidateTag(ctx, "user:42"))
+
+	for _, key := range []string{"user:42:profile", "user:42:orders"} {
+		_, err = memory.Get(ctx, key)
+		assert.Error(t, err, "Tagged key must leave the memory layer")
+		_, ok := warm.value(key)
+		assert.False(t, ok, "Tagged key must leave every layer")
+	}
+	value, err := cache.Get(ctx, "user:7:profile")
+	assert.NoError(t, err)
+	assert.Equal(t, "p7", value)
+
+	keys, _ := memory.TagKeys(ctx, "user:42")
+	assert.Empty(t, keys)
+	keys, _ = memory.TagKeys(ctx, "orders")
+	assert.Empty(t, keys, "Deleted keys must leave the index of their other tags")
+	keys, _ = memory.TagKeys(ctx, "user:7")
+	assert.Equal(t, []string{"user:7:profile"}, keys)
+}
+
+func TestMemoryCache_TagIndexPruning(t *testing.T) {
+	ctx := context.Background()
+	ram, err := storage.NewRistrettoCache(ctx, 10, storage.WithRegisterer(prometheus.NewRegistry()))
+	require.NoError(t, err)
+	memory := NewMemoryCache(ram)
+	t.Cleanup(memory.Stop)
+
+	require.NoError(t, memory.Set(ctx, "k1", "v1", time.Minute))
+	require.NoError(t, memory.Set(ctx, "k2", "v2", time.Minute))
+	require.NoError(t, memory.AddTags(ctx, "k1", []string{"a", "b"}, time.Minute))
+	require.NoError(t, memory.AddTags(ctx, "k2", []string{"a"}, time.Minute))
+
+	// A key removed from Ristretto without Delete is pruned on the next read of its tag
+	ram.Delete(ctx, "k2")
+	keys, _ := memory.TagKeys(ctx, "a")
+	assert.Equal(t, []string{"k1"}, keys)
+
+	memory.Delete(ctx, "k1")
+	assert.Empty(t, memory.tags)
+	assert.Empty(t, memory.keyTags)
+}
+
+func TestMultiTierCache_SetWithTags_Skipped(t *testing.T) {
+	ctx := context.Background()
+	ram, err := storage.NewRistrettoCache(ctx, 10, storage.WithRegisterer(prometheus.NewRegistry()))
+	require.NoError(t, err)
+	memory := NewMemoryCache(ram)
+	cache := newTestCache(t, MultiTierCacheConfig{
+		Layers:     []LayerInfo{NewLayerInfo(memory)},
+		DB:         newBatchTestStore("db", nil),
+		Thresholds: []int{0},
+	})
+
+	require.NoError(t, cache.Set(ctx, "key1", "value1"))
+	_, err = memory.Get(ctx, "key1")
+	require.NoError(t, err)
+
+	// The write plan skips a key whose current TTL is not shorter than the adaptive one
+	cache.ttlManager.AdjustTTL("key1", 1<<40)
+	require.NoError(t, cache.SetWithTags(ctx, "key1", "value1", "tag1"))
+	keys, _ := memory.TagKeys(ctx, "tag1")
+	assert.Equal(t, []string{"key1"}, keys, "A key the write plan skipped is still tagged")
+
+	require.NoError(t, cache.InvalidateTag(ctx, "tag1"))
+	_, err = memory.Get(ctx, "key1")
+	assert.Error(t, err, "InvalidateTag reaches the skipped key")
+}
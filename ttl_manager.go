@@ -3,19 +3,51 @@ package multi_tier_caching
 import (
 	"log"
 	"sync"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 )
 
+// softExpiryPruneInterval is how often SetSoftExpiry drops the soft expiries of keys past their hard TTL
+const softExpiryPruneInterval = time.Minute
+
+// softExpiry is the soft expiry of a key and the hard expiry after which it is forgotten
+type softExpiry struct {
+	at    time.Time
+	until time.Time
+}
+
 type TTLManager struct {
-	ttls  map[string]int64
-	mu    sync.Mutex
-	debug bool
+	ttls        map[string]int64
+	softExpires map[string]softExpiry // Soft expiry for layers that cannot persist entry metadata
+	lastPrune   time.Time
+	mu          sync.Mutex
+	ttlChanges  prometheus.Histogram
+	metricsCfg  MetricsConfig
+	debug       bool
+}
+
+// TTLManagerOption configures optional TTLManager behaviour
+type TTLManagerOption func(*TTLManager)
+
+// WithTTLMetrics sets the registry, namespace and labels of the TTL metrics
+func WithTTLMetrics(metrics MetricsConfig) TTLManagerOption {
+	return func(tm *TTLManager) {
+		tm.metricsCfg = metrics
+	}
 }
 
-func NewTTLManager(debug bool) *TTLManager {
-	prometheus.MustRegister(ttlChangeHistogram)
-	return &TTLManager{ttls: make(map[string]int64), debug: debug}
+func NewTTLManager(debug bool, opts ...TTLManagerOption) *TTLManager {
+	tm := &TTLManager{
+		ttls:        make(map[string]int64),
+		softExpires: make(map[string]softExpiry),
+		debug:       debug,
+	}
+	for _, opt := range opts {
+		opt(tm)
+	}
+	tm.ttlChanges = newTTLChangeHistogram(tm.metricsCfg)
+	return tm
 }
 
 func (tm *TTLManager) AdjustTTL(key string, newTTL int64) {
@@ -23,7 +55,7 @@ func (tm *TTLManager) AdjustTTL(key string, newTTL int64) {
 	defer tm.mu.Unlock()
 	if newTTL > tm.ttls[key] {
 		tm.ttls[key] = newTTL
-		ttlChangeHistogram.WithLabelValues(key).Observe(float64(newTTL))
+		tm.ttlChanges.Observe(float64(newTTL))
 	}
 }
 
@@ -33,6 +65,50 @@ func (tm *TTLManager) GetTTL(key string) int64 {
 	return tm.ttls[key]
 }
 
+// RemoveTTL forgets the TTL tracked for a key
+func (tm *TTLManager) RemoveTTL(key string) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	delete(tm.ttls, key)
+	delete(tm.softExpires, key)
+}
+
+// SetSoftExpiry remembers when the cached value of the key becomes stale, until its hard TTL passes
+func (tm *TTLManager) SetSoftExpiry(key string, at time.Time, ttl time.Duration) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	now := time.Now()
+	tm.softExpires[key] = softExpiry{at: at, until: now.Add(ttl)}
+	if now.Sub(tm.lastPrune) >= softExpiryPruneInterval {
+		tm.pruneSoftExpiries(now)
+	}
+}
+
+// GetSoftExpiry returns the soft expiry of the key, zero if unknown or past the hard TTL
+func (tm *TTLManager) GetSoftExpiry(key string) time.Time {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	expiry, ok := tm.softExpires[key]
+	if !ok {
+		return time.Time{}
+	}
+	if !time.Now().Before(expiry.until) {
+		delete(tm.softExpires, key)
+		return time.Time{}
+	}
+	return expiry.at
+}
+
+// pruneSoftExpiries drops the soft expiries of keys whose cached values have expired, tm.mu must be held
+func (tm *TTLManager) pruneSoftExpiries(now time.Time) {
+	for key, expiry := range tm.softExpires {
+		if !now.Before(expiry.until) {
+			delete(tm.softExpires, key)
+		}
+	}
+	tm.lastPrune = now
+}
+
 func (tm *TTLManager) calculateAdaptiveTTL(freq int) int64 {
 	baseTTL := int64(60) // Base TTL in seconds
 	var ttl int64
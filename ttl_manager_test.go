@@ -2,12 +2,14 @@ package multi_tier_caching
 
 import (
 	"testing"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestTTLManager(t *testing.T) {
-	tm := NewTTLManager(false)
+	tm := NewTTLManager(false, WithTTLMetrics(MetricsConfig{Registerer: prometheus.NewRegistry()}))
 
 	key := "test_key"
 
@@ -25,3 +27,21 @@ func TestTTLManager(t *testing.T) {
 	ttl = tm.GetTTL(key)
 	assert.Equal(t, int64(30), ttl, "TTL should increase to 30")
 }
+
+func TestTTLManager_SoftExpiryPruning(t *testing.T) {
+	tm := NewTTLManager(false, WithTTLMetrics(MetricsConfig{Registerer: prometheus.NewRegistry()}))
+	softExpiry := time.Now().Add(time.Minute)
+
+	tm.SetSoftExpiry("live", softExpiry, time.Hour)
+	tm.SetSoftExpiry("expired", softExpiry, -time.Second)
+	assert.True(t, tm.GetSoftExpiry("live").Equal(softExpiry))
+	assert.True(t, tm.GetSoftExpiry("expired").IsZero(), "A key past its hard TTL has no soft expiry")
+	assert.NotContains(t, tm.softExpires, "expired")
+
+	// Keys that are never read again are dropped by the periodic sweep
+	tm.SetSoftExpiry("unread", softExpiry, -time.Second)
+	tm.lastPrune = time.Now().Add(-softExpiryPruneInterval)
+	tm.SetSoftExpiry("other", softExpiry, time.Hour)
+	assert.NotContains(t, tm.softExpires, "unread")
+	assert.Len(t, tm.softExpires, 2)
+}
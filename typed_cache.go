@@ -0,0 +1,173 @@
+package multi_tier_caching
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Codec converts typed values to the string form stored in the cache layers
+type Codec[V any] interface {
+	Encode(value V) (string, error)
+	Decode(data string) (V, error)
+}
+
+// DecodeError is returned when a cached value cannot be decoded by the codec
+type DecodeError struct {
+	Key string
+	Err error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("decode key=%s: %v", e.Key, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// JSONCodec encodes values with encoding/json
+type JSONCodec[V any] struct{}
+
+func (JSONCodec[V]) Encode(value V) (string, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func (JSONCodec[V]) Decode(data string) (V, error) {
+	var value V
+	err := json.Unmarshal([]byte(data), &value)
+	return value, err
+}
+
+// GobCodec encodes values with encoding/gob
+type GobCodec[V any] struct{}
+
+func (GobCodec[V]) Encode(value V) (string, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(value); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func (GobCodec[V]) Decode(data string) (V, error) {
+	var value V
+	err := gob.NewDecoder(bytes.NewBufferString(data)).Decode(&value)
+	return value, err
+}
+
+// BytesCodec stores raw bytes as is
+type BytesCodec struct{}
+
+func (BytesCodec) Encode(value []byte) (string, error) {
+	return string(value), nil
+}
+
+func (BytesCodec) Decode(data string) ([]byte, error) {
+	return []byte(data), nil
+}
+
+// TypedCache — typed facade over MultiTierCache, values are encoded once before they reach the layers
+type TypedCache[V any] struct {
+	cache *MultiTierCache
+	codec Codec[V]
+}
+
+func NewTypedCache[V any](cache *MultiTierCache, codec Codec[V]) *TypedCache[V] {
+	return &TypedCache[V]{cache: cache, codec: codec}
+}
+
+func (t *TypedCache[V]) Get(ctx context.Context, key string) (V, error) {
+	var zero V
+	data, err := t.cache.Get(ctx, key)
+	if err != nil {
+		return zero, err
+	}
+	return t.decode(key, data)
+}
+
+func (t *TypedCache[V]) Set(ctx context.Context, key string, value V) error {
+	data, err := t.codec.Encode(value)
+	if err != nil {
+		return fmt.Errorf("encode key=%s: %w", key, err)
+	}
+	return t.cache.Set(ctx, key, data)
+}
+
+// SetWithPolicy is the typed variant of MultiTierCache.SetWithPolicy
+func (t *TypedCache[V]) SetWithPolicy(ctx context.Context, key string, value V, policy WritePolicy) error {
+	data, err := t.codec.Encode(value)
+	if err != nil {
+		return fmt.Errorf("encode key=%s: %w", key, err)
+	}
+	return t.cache.SetWithPolicy(ctx, key, data, policy)
+}
+
+// GetOrLoad is the typed variant of MultiTierCache.GetOrLoad
+func (t *TypedCache[V]) GetOrLoad(ctx context.Context, key string, loader func(ctx context.Context) (V, time.Duration, error)) (V, error) {
+	var zero V
+	data, err := t.cache.GetOrLoad(ctx, key, func(ctx context.Context) (string, time.Duration, error) {
+		value, ttl, err := loader(ctx)
+		if err != nil {
+			return "", 0, err
+		}
+		data, err := t.codec.Encode(value)
+		if err != nil {
+			return "", 0, fmt.Errorf("encode key=%s: %w", key, err)
+		}
+		return data, ttl, nil
+	})
+	if err != nil {
+		return zero, err
+	}
+	return t.decode(key, data)
+}
+
+// MGet is the typed variant of MultiTierCache.MGet, it fails on the first value that cannot be decoded
+func (t *TypedCache[V]) MGet(ctx context.Context, keys []string) (map[string]V, error) {
+	data, err := t.cache.MGet(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]V, len(data))
+	for key, raw := range data {
+		value, err := t.decode(key, raw)
+		if err != nil {
+			return nil, err
+		}
+		result[key] = value
+	}
+	return result, nil
+}
+
+func (t *TypedCache[V]) MSet(ctx context.Context, items map[string]V) error {
+	data := make(map[string]string, len(items))
+	for key, value := range items {
+		raw, err := t.codec.Encode(value)
+		if err != nil {
+			return fmt.Errorf("encode key=%s: %w", key, err)
+		}
+		data[key] = raw
+	}
+	return t.cache.MSet(ctx, data)
+}
+
+func (t *TypedCache[V]) Delete(ctx context.Context, key string) error {
+	return t.cache.Delete(ctx, key)
+}
+
+func (t *TypedCache[V]) decode(key, data string) (V, error) {
+	value, err := t.codec.Decode(data)
+	if err != nil {
+		var zero V
+		return zero, &DecodeError{Key: key, Err: err}
+	}
+	return value, nil
+}
@@ -0,0 +1,50 @@
+package multi_tier_caching
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type typedTestValue struct {
+	ID   int
+	Name string
+}
+
+func TestTypedCache_Codecs(t *testing.T) {
+	ctx := context.Background()
+	cache, _ := newMemoryTestCache(t)
+	value := typedTestValue{ID: 42, Name: "answer"}
+
+	jsonCache := NewTypedCache[typedTestValue](cache, JSONCodec[typedTestValue]{})
+	assert.NoError(t, jsonCache.Set(ctx, "json", value))
+	got, err := jsonCache.Get(ctx, "json")
+	assert.NoError(t, err)
+	assert.Equal(t, value, got)
+
+	gobCache := NewTypedCache[typedTestValue](cache, GobCodec[typedTestValue]{})
+	assert.NoError(t, gobCache.Set(ctx, "gob", value))
+	got, err = gobCache.Get(ctx, "gob")
+	assert.NoError(t, err)
+	assert.Equal(t, value, got)
+
+	bytesCache := NewTypedCache[[]byte](cache, BytesCodec{})
+	assert.NoError(t, bytesCache.Set(ctx, "bytes", []byte{0, 1, 2}))
+	raw, err := bytesCache.Get(ctx, "bytes")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0, 1, 2}, raw)
+}
+
+func TestTypedCache_DecodeError(t *testing.T) {
+	ctx := context.Background()
+	cache, _ := newMemoryTestCache(t)
+	assert.NoError(t, cache.Set(ctx, "broken", "{not json"))
+
+	typed := NewTypedCache[typedTestValue](cache, JSONCodec[typedTestValue]{})
+	_, err := typed.Get(ctx, "broken")
+
+	var decodeErr *DecodeError
+	assert.ErrorAs(t, err, &decodeErr)
+	assert.Equal(t, "broken", decodeErr.Key)
+}
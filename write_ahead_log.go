@@ -0,0 +1,331 @@
+package multi_tier_caching
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	walFileName = "write_queue.wal"
+	// walCompactAfter is the number of acknowledgements after which the log is rewritten
+	// even if some tasks are still pending
+	walCompactAfter = 1024
+)
+
+// walRecord is one JSON line of the write-ahead log
+type walRecord struct {
+	Op         string        `json:"op"` // "put" or "ack"
+	Seq        uint64        `json:"seq"`
+	Key        string        `json:"key,omitempty"`
+	Value      string        `json:"value,omitempty"`
+	TTL        time.Duration `json:"ttl,omitempty"`
+	StoredAt   time.Time     `json:"stored_at"`
+	SoftExpiry time.Time     `json:"soft_expiry"`
+}
+
+// WriteAheadLog is an append-only log of write-behind tasks. Tasks are fsynced in groups
+// before Enqueue returns and acknowledged once persisted, unacknowledged tasks are replayed on startup.
+type WriteAheadLog struct {
+	path    string
+	debug   bool
+	syncMu  sync.Mutex // Serializes fsync, concurrent appends share one group commit
+	synced  uint64     // Last sequence known to be on disk, guarded by syncMu
+	mu      sync.Mutex // Guards the fields below
+	file    *os.File
+	writer  *bufio.Writer
+	seq     uint64
+	pending map[uint64]WriteTask
+	acks    int // Acknowledgements since the last compaction
+	closed  bool
+}
+
+// OpenWAL opens (or creates) the log in dir and loads the tasks that were not acknowledged yet
+func OpenWAL(dir string, debug bool) (*WriteAheadLog, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create wal dir: %w", err)
+	}
+	l := &WriteAheadLog{
+		path:    filepath.Join(dir, walFileName),
+		debug:   debug,
+		pending: make(map[uint64]WriteTask),
+	}
+	if err := l.load(); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open wal: %w", err)
+	}
+	l.file = file
+	l.writer = bufio.NewWriter(file)
+	l.synced = l.seq
+	if debug {
+		log.Printf("[WAL] Opened %s, %d pending task(s)", l.path, len(l.pending))
+	}
+	return l, nil
+}
+
+// load replays the log file. A torn last line left by a crash is cut off, so the records
+// appended next start on a line of their own.
+func (l *WriteAheadLog) load() error {
+	file, err := os.Open(l.path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("open wal: %w", err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReaderSize(file, 64*1024)
+	var valid int64 // Offset past the last complete record
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(line) > 0 {
+				log.Printf("[WAL] Ignoring torn record at the end of %s", l.path)
+			}
+			break
+		} else if err != nil {
+			return fmt.Errorf("read wal: %w", err)
+		}
+		var record walRecord
+		if err = json.Unmarshal(line, &record); err != nil {
+			log.Printf("[WAL] Ignoring unreadable record in %s and the records after it: %v", l.path, err)
+			break
+		}
+		valid += int64(len(line))
+		if record.Seq > l.seq {
+			l.seq = record.Seq
+		}
+		switch record.Op {
+		case "put":
+			l.pending[record.Seq] = WriteTask{
+				Key:        record.Key,
+				Value:      record.Value,
+				TTL:        record.TTL,
+				StoredAt:   record.StoredAt,
+				SoftExpiry: record.SoftExpiry,
+				seq:        record.Seq,
+			}
+		case "ack":
+			delete(l.pending, record.Seq)
+		}
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("stat wal: %w", err)
+	}
+	if info.Size() > valid {
+		if err = os.Truncate(l.path, valid); err != nil {
+			return fmt.Errorf("truncate wal: %w", err)
+		}
+	}
+	return nil
+}
+
+// Pending returns the unacknowledged tasks in the order they were appended
+func (l *WriteAheadLog) Pending() []WriteTask {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.pendingLocked()
+}
+
+func (l *WriteAheadLog) pendingLocked() []WriteTask {
+	tasks := make([]WriteTask, 0, len(l.pending))
+	for _, task := range l.pending {
+		tasks = append(tasks, task)
+	}
+	sort.Slice(tasks, func(i, j int) bool { return tasks[i].seq < tasks[j].seq })
+	return tasks
+}
+
+// Append durably records the task and returns its sequence number
+func (l *WriteAheadLog) Append(task WriteTask) (uint64, error) {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return 0, fmt.Errorf("wal is closed")
+	}
+	l.seq++
+	seq := l.seq
+	task.seq = seq
+	err := l.writeRecord(walRecord{
+		Op:         "put",
+		Seq:        seq,
+		Key:        task.Key,
+		Value:      task.Value,
+		TTL:        task.TTL,
+		StoredAt:   task.StoredAt,
+		SoftExpiry: task.SoftExpiry,
+	})
+	if err == nil {
+		l.pending[seq] = task
+	}
+	l.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	return seq, l.sync(seq)
+}
+
+// sync makes every record up to seq durable. Appends that arrive while a fsync
+// is running are covered by the next one, so concurrent writers share the cost.
+func (l *WriteAheadLog) sync(seq uint64) error {
+	l.syncMu.Lock()
+	defer l.syncMu.Unlock()
+	if l.synced >= seq {
+		return nil
+	}
+
+	l.mu.Lock()
+	upTo := l.seq
+	err := l.writer.Flush()
+	file := l.file
+	l.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("flush wal: %w", err)
+	}
+	if err = file.Sync(); err != nil {
+		return fmt.Errorf("fsync wal: %w", err)
+	}
+	l.synced = upTo
+	return nil
+}
+
+// Ack marks the task as persisted. Acknowledgements are not fsynced: a lost ack only
+// replays an already persisted task. The log is compacted when nothing is pending.
+func (l *WriteAheadLog) Ack(seq uint64) error {
+	if seq == 0 {
+		return nil
+	}
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil
+	}
+	delete(l.pending, seq)
+	err := l.writeRecord(walRecord{Op: "ack", Seq: seq})
+	l.acks++
+	compact := len(l.pending) == 0 || l.acks >= walCompactAfter
+	l.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if compact {
+		return l.Compact()
+	}
+	return nil
+}
+
+// Compact rewrites the log so that it only holds the pending tasks
+func (l *WriteAheadLog) Compact() error {
+	l.syncMu.Lock()
+	defer l.syncMu.Unlock()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return nil
+	}
+
+	l.acks = 0
+	if len(l.pending) == 0 {
+		// Fast path: everything is persisted, the log can be emptied in place
+		l.writer.Reset(l.file)
+		if err := l.file.Truncate(0); err != nil {
+			return fmt.Errorf("truncate wal: %w", err)
+		}
+		l.synced = l.seq
+		return l.file.Sync()
+	}
+
+	tmpPath := l.path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return fmt.Errorf("create compacted wal: %w", err)
+	}
+	writer := bufio.NewWriter(tmp)
+	encoder := json.NewEncoder(writer)
+	for _, task := range l.pendingLocked() {
+		err = encoder.Encode(walRecord{
+			Op:         "put",
+			Seq:        task.seq,
+			Key:        task.Key,
+			Value:      task.Value,
+			TTL:        task.TTL,
+			StoredAt:   task.StoredAt,
+			SoftExpiry: task.SoftExpiry,
+		})
+		if err != nil {
+			tmp.Close()
+			return fmt.Errorf("write compacted wal: %w", err)
+		}
+	}
+	if err = writer.Flush(); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("write compacted wal: %w", err)
+	}
+	if err = os.Rename(tmpPath, l.path); err != nil {
+		return fmt.Errorf("replace wal: %w", err)
+	}
+
+	l.file.Close()
+	file, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("reopen wal: %w", err)
+	}
+	l.file = file
+	l.writer.Reset(file)
+	l.synced = l.seq
+	if l.debug {
+		log.Printf("[WAL] Compacted %s, %d pending task(s)", l.path, len(l.pending))
+	}
+	return nil
+}
+
+// Close flushes buffered records and closes the file
+func (l *WriteAheadLog) Close() error {
+	l.syncMu.Lock()
+	defer l.syncMu.Unlock()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+	err := l.writer.Flush()
+	if syncErr := l.file.Sync(); err == nil {
+		err = syncErr
+	}
+	if closeErr := l.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// writeRecord appends one record to the buffer, the caller holds mu
+func (l *WriteAheadLog) writeRecord(record walRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("encode wal record: %w", err)
+	}
+	data = append(data, '\n')
+	if _, err = l.writer.Write(data); err != nil {
+		return fmt.Errorf("write wal: %w", err)
+	}
+	return nil
+}
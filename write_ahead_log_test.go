@@ -0,0 +1,119 @@
+package multi_tier_caching
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteAheadLog_ReplayUnacknowledged(t *testing.T) {
+	dir := t.TempDir()
+
+	wal, err := OpenWAL(dir, false)
+	require.NoError(t, err)
+	seq1, err := wal.Append(WriteTask{Key: "key1", Value: "value1", TTL: time.Minute})
+	require.NoError(t, err)
+	_, err = wal.Append(WriteTask{Key: "key2", Value: "value2", TTL: time.Minute})
+	require.NoError(t, err)
+	_, err = wal.Append(WriteTask{Key: "key3", Value: "value3", TTL: time.Minute})
+	require.NoError(t, err)
+	require.NoError(t, wal.Ack(seq1))
+	require.NoError(t, wal.Close()) // Simulated crash: key2 and key3 were never persisted
+
+	// A torn record left by a crash must not prevent the replay
+	file, err := os.OpenFile(filepath.Join(dir, walFileName), os.O_WRONLY|os.O_APPEND, 0o644)
+	require.NoError(t, err)
+	_, err = file.WriteString(`{"op":"put","seq":4,"key":"to`)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	wal, err = OpenWAL(dir, false)
+	require.NoError(t, err)
+	pending := wal.Pending()
+	require.Len(t, pending, 2)
+	assert.Equal(t, "key2", pending[0].Key)
+	assert.Equal(t, "key3", pending[1].Key)
+	assert.Equal(t, time.Minute, pending[1].TTL)
+
+	// Records appended after the crash survive the next restart
+	_, err = wal.Append(WriteTask{Key: "key4", Value: "value4"})
+	require.NoError(t, err)
+	require.NoError(t, wal.Close())
+	wal, err = OpenWAL(dir, false)
+	require.NoError(t, err)
+	pending = wal.Pending()
+	require.Len(t, pending, 3)
+	assert.Equal(t, "key4", pending[2].Key)
+	require.NoError(t, wal.Close())
+}
+
+func TestWriteQueue_WALReplayAndCompaction(t *testing.T) {
+	dir := t.TempDir()
+
+	wal, err := OpenWAL(dir, false)
+	require.NoError(t, err)
+	for _, key := range []string{"key1", "key2"} {
+		_, err = wal.Append(WriteTask{Key: key, Value: "value"})
+		require.NoError(t, err)
+	}
+	require.NoError(t, wal.Close())
+
+	var processed []string
+	var mu sync.Mutex
+	wal, err = OpenWAL(dir, false)
+	require.NoError(t, err)
+	wq := NewWriteQueue(func(_ context.Context, tasks []WriteTask) error {
+		mu.Lock()
+		for _, task := range tasks {
+			processed = append(processed, task.Key)
+		}
+		mu.Unlock()
+		return nil
+	}, false, withTestMetrics(), WithWAL(wal))
+	assert.NoError(t, wq.Enqueue(context.Background(), WriteTask{Key: "key3", Value: "value"}))
+
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(processed) == 3
+	}, 5*time.Second, 50*time.Millisecond)
+	_, err = wq.Shutdown(context.Background())
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"key1", "key2", "key3"}, processed, "Replayed tasks must be processed before new ones")
+	info, err := os.Stat(filepath.Join(dir, walFileName))
+	require.NoError(t, err)
+	assert.Zero(t, info.Size(), "The log must be compacted once every task is processed")
+}
+
+func TestMultiTierCache_WALOpenFailure(t *testing.T) {
+	ctx := context.Background()
+	// A regular file where the log directory should be
+	path := filepath.Join(t.TempDir(), "wal")
+	require.NoError(t, os.WriteFile(path, nil, 0o644))
+	config := MultiTierCacheConfig{
+		Layers:      []LayerInfo{NewLayerInfo(newBatchTestStore("memory", nil))},
+		DB:          newBatchTestStore("db", nil),
+		Thresholds:  []int{0},
+		BloomSize:   1000,
+		BloomHashes: 5,
+		WALDir:      path,
+		Registerer:  prometheus.NewRegistry(),
+	}
+
+	_, err := OpenMultiTierCache(ctx, config)
+	assert.ErrorContains(t, err, "write-ahead log")
+
+	// NewMultiTierCache keeps running without the log
+	cache := NewMultiTierCache(ctx, config)
+	t.Cleanup(cache.Close)
+	assert.Nil(t, cache.writeQueue.wal)
+	assert.NoError(t, cache.Set(ctx, "key1", "value1"))
+}
@@ -1,103 +1,859 @@
 package multi_tier_caching
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
 	"log"
 	"sync"
+	"sync/atomic"
 	"time"
+)
 
-	"github.com/prometheus/client_golang/prometheus"
+const (
+	DefaultWriteBatchSize = 100
+	DefaultWriteMaxLinger = 500 * time.Millisecond
+	// DefaultShutdownTimeout bounds the drain of the write queue in MultiTierCache.Close
+	DefaultShutdownTimeout = 10 * time.Second
 )
 
 type WriteTask struct {
-	Key   string
-	Value string
-	TTL   time.Duration
+	Key        string
+	Value      string
+	TTL        time.Duration
+	StoredAt   time.Time // Entry metadata, zero unless stale-while-revalidate is enabled
+	SoftExpiry time.Time
+	seq        uint64 // Write-ahead log sequence, 0 without a log
+	pos        uint64 // Position in the shard, used by Flush to wait for earlier tasks
 }
 
+// WriteQueue — write-behind queue. Pending tasks are coalesced per key, so only the latest
+// value of a key is written, and handed to the processor in batches. Keys are hashed to a
+// fixed worker: writes of one key stay ordered while different keys are persisted in parallel.
 type WriteQueue struct {
-	debug     bool
-	queue     []WriteTask
-	mu        sync.Mutex
-	cond      *sync.Cond
-	processor func(task WriteTask)
-	stopChan  chan struct{}
-	interval  time.Duration
+	debug       bool
+	shards      []*writeShard
+	processor   func(ctx context.Context, tasks []WriteTask) error
+	wal         *WriteAheadLog
+	workers     int
+	batchSize   int           // Maximum number of tasks per batch, a full batch is flushed immediately
+	maxLinger   time.Duration // Maximum time a partial batch waits for more tasks
+	capacity    int           // Maximum number of pending tasks, 0 for an unbounded queue
+	overflow    OverflowPolicy
+	retry       RetryPolicy
+	deadLetters DeadLetterSink // Receives the tasks of batches that failed every attempt
+	metricsCfg  MetricsConfig
+	metrics     *writeQueueMetrics
+	length      atomic.Int64 // Pending tasks across all shards
+	closed      atomic.Bool  // Set by Shutdown, Enqueue rejects new tasks
+	runMu       sync.Mutex   // Serializes Start and Stop
+	run         atomic.Pointer[queueRun]
+	// superseded drops requeued letters of keys written since they failed, may be nil
+	superseded func(ctx context.Context, task WriteTask) bool
+}
+
+// queueRun is one run of the workers, from Start to Stop
+type queueRun struct {
+	ctx     context.Context // Passed to the processor, cancelled by Stop and when Shutdown gives up on a batch
+	cancel  context.CancelFunc
+	stop    chan struct{} // Closed by Stop
+	stopped bool          // Guarded by runMu
+	workers sync.WaitGroup
+}
+
+// writeShard holds the pending tasks of the keys hashed to one worker
+type writeShard struct {
+	queue    *WriteQueue
+	mu       sync.Mutex
+	cond     *sync.Cond
+	pending  map[string]WriteTask // Latest pending task per key
+	flushing map[string]struct{}  // Keys of the batch being processed
+	order    []string             // Pending keys in the order they were first enqueued
+	capacity int                  // Share of the queue capacity, 0 when unbounded
+	reserved int                  // Slots taken by tasks being written to the log
+	full     chan struct{}        // Signalled when a full batch is pending
+	space    chan struct{}        // Closed and replaced whenever pending tasks leave a bounded shard
+	progress chan struct{}        // Closed and replaced whenever tasks are finished, cancelled or dropped
+	added    uint64               // Position of the last added task
+	inFlight uint64               // Position of the first task of the batch being processed, 0 when idle
+	urgent   int                  // Running Flush calls, partial batches do not linger while it is positive
+	failed   []uint64             // Positions of the tasks dead-lettered while urgent is positive
+	stopped  bool
+}
+
+// WriteQueueOption configures optional WriteQueue behaviour
+type WriteQueueOption func(*WriteQueue)
+
+// WithWAL makes the queue durable: tasks are recorded in the log before Enqueue returns
+// and the tasks the log still holds are replayed by NewWriteQueue
+func WithWAL(wal *WriteAheadLog) WriteQueueOption {
+	return func(w *WriteQueue) {
+		w.wal = wal
+	}
+}
+
+// WithWorkers sets the number of workers persisting batches concurrently
+func WithWorkers(workers int) WriteQueueOption {
+	return func(w *WriteQueue) {
+		if workers > 0 {
+			w.workers = workers
+		}
+	}
+}
+
+// WithBatchSize sets the maximum number of tasks handed to the processor at once
+func WithBatchSize(size int) WriteQueueOption {
+	return func(w *WriteQueue) {
+		if size > 0 {
+			w.batchSize = size
+		}
+	}
+}
+
+// WithMaxLinger sets how long a partial batch waits for more tasks before it is flushed
+func WithMaxLinger(linger time.Duration) WriteQueueOption {
+	return func(w *WriteQueue) {
+		if linger > 0 {
+			w.maxLinger = linger
+		}
+	}
+}
+
+// WithCapacity bounds the number of pending tasks, the policy decides what happens to writes
+// that arrive while the queue is full. The capacity is split between the workers, the policy
+// applies once the share of a key's worker is used up. A queue has at most capacity workers.
+func WithCapacity(capacity int, policy OverflowPolicy) WriteQueueOption {
+	return func(w *WriteQueue) {
+		if capacity > 0 {
+			w.capacity = capacity
+			w.overflow = policy
+		}
+	}
+}
+
+// WithRetryPolicy sets how failed batches are retried
+func WithRetryPolicy(policy RetryPolicy) WriteQueueOption {
+	return func(w *WriteQueue) {
+		w.retry = policy.withDefaults()
+	}
 }
 
-func NewWriteQueue(processor func(task WriteTask), debug bool) *WriteQueue {
+// WithMetrics sets the registry, namespace and labels of the queue metrics
+func WithMetrics(metrics MetricsConfig) WriteQueueOption {
+	return func(w *WriteQueue) {
+		w.metricsCfg = metrics
+	}
+}
+
+// WithDeadLetterSink sets where tasks go once their batch failed every attempt,
+// an in-memory sink is used by default
+func WithDeadLetterSink(sink DeadLetterSink) WriteQueueOption {
+	return func(w *WriteQueue) {
+		if sink != nil {
+			w.deadLetters = sink
+		}
+	}
+}
+
+// WithSuperseded sets the check RequeueDeadLetters uses to drop letters whose key was written
+// again since they failed: fn reports whether a newer value of the task's key is persisted
+func WithSuperseded(fn func(ctx context.Context, task WriteTask) bool) WriteQueueOption {
+	return func(w *WriteQueue) {
+		w.superseded = fn
+	}
+}
+
+// NewWriteQueue creates a queue handing batches to the processor. The processor context is
+// cancelled when Shutdown runs out of time, a processor honouring it lets the workers stop.
+func NewWriteQueue(processor func(ctx context.Context, tasks []WriteTask) error, debug bool, opts ...WriteQueueOption) *WriteQueue {
 	wq := &WriteQueue{
-		queue:     make([]WriteTask, 0),
-		processor: processor,
-		stopChan:  make(chan struct{}),
-		debug:     debug,
-		interval:  600 * time.Millisecond,
-	}
-	wq.cond = sync.NewCond(&wq.mu)
-	go wq.startWorker()
-	prometheus.MustRegister(queueLengthGauge, processedTasksCounter, taskProcessingHistogram)
+		processor:   processor,
+		workers:     1,
+		batchSize:   DefaultWriteBatchSize,
+		maxLinger:   DefaultWriteMaxLinger,
+		retry:       DefaultRetryPolicy,
+		deadLetters: NewMemoryDeadLetterSink(),
+		debug:       debug,
+	}
+	for _, opt := range opts {
+		opt(wq)
+	}
+	if wq.capacity > 0 && wq.workers > wq.capacity {
+		// Every worker needs a slot, the shares must not add up to more than the capacity
+		wq.workers = wq.capacity
+	}
+	wq.metrics = newWriteQueueMetrics(wq.metricsCfg)
+	wq.shards = make([]*writeShard, wq.workers)
+	for i := range wq.shards {
+		shard := &writeShard{
+			queue:    wq,
+			pending:  make(map[string]WriteTask),
+			flushing: make(map[string]struct{}),
+			order:    make([]string, 0),
+			full:     make(chan struct{}, 1),
+			space:    make(chan struct{}),
+			progress: make(chan struct{}),
+		}
+		if wq.capacity > 0 {
+			// The first capacity%workers shards take the remainder, the shares add up to the capacity
+			shard.capacity = wq.capacity / wq.workers
+			if i < wq.capacity%wq.workers {
+				shard.capacity++
+			}
+		}
+		shard.cond = sync.NewCond(&shard.mu)
+		wq.shards[i] = shard
+	}
+	if wq.wal != nil {
+		replay := wq.wal.Pending()
+		if debug && len(replay) > 0 {
+			log.Printf("[WRITE QUEUE] Replaying %d task(s) from the write-ahead log", len(replay))
+		}
+		for _, task := range replay {
+			wq.shardFor(task.Key).add(task)
+		}
+	}
+	wq.updateGauges()
+	wq.Start(context.Background())
 	return wq
 }
 
-func (w *WriteQueue) Enqueue(task WriteTask) {
+// Start launches the workers unless they run, NewWriteQueue calls it. The workers run until
+// ctx is done, Stop or Shutdown; a stopped queue can be started again, a shut down one cannot.
+func (w *WriteQueue) Start(ctx context.Context) {
+	if w.closed.Load() {
+		return
+	}
+	w.runMu.Lock()
+	defer w.runMu.Unlock()
+	if run := w.run.Load(); run != nil && !run.stopped {
+		return
+	}
+	run := &queueRun{stop: make(chan struct{})}
+	run.ctx, run.cancel = context.WithCancel(ctx)
+	for _, shard := range w.shards {
+		shard.mu.Lock()
+		shard.stopped = false
+		shard.mu.Unlock()
+		run.workers.Add(1)
+		go shard.startWorker(run)
+	}
+	w.run.Store(run)
+	go func() {
+		select {
+		case <-ctx.Done():
+			w.stop(run)
+		case <-run.stop:
+		}
+	}()
+}
+
+// stopped returns a channel closed once the workers are stopped
+func (w *WriteQueue) stopped() <-chan struct{} {
+	return w.run.Load().stop
+}
+
+// shardFor returns the shard owning the key
+func (w *WriteQueue) shardFor(key string) *writeShard {
+	if len(w.shards) == 1 {
+		return w.shards[0]
+	}
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return w.shards[h.Sum32()%uint32(len(w.shards))]
+}
+
+// Enqueue queues the task. When a bounded queue is full the overflow policy applies:
+// OverflowBlock waits until ctx is done, OverflowFail returns ErrQueueFull.
+func (w *WriteQueue) Enqueue(ctx context.Context, task WriteTask) error {
+	return w.enqueue(ctx, task, w.overflow)
+}
+
+// enqueue queues the task applying the overflow policy. The task is written to the log only
+// once its slot is reserved, so a rejected task is never logged.
+func (w *WriteQueue) enqueue(ctx context.Context, task WriteTask, overflow OverflowPolicy) error {
+	if w.closed.Load() {
+		return ErrQueueClosed
+	}
 	if w.debug {
 		log.Printf("[WRITE QUEUE] Enqueuing task for key=%s", task.Key)
 	}
-	w.mu.Lock()
-	w.queue = append(w.queue, task)
-	queueLengthGauge.Set(float64(len(w.queue)))
-	w.updateInterval()
-	w.mu.Unlock()
-	w.cond.Signal()
+	shard := w.shardFor(task.Key)
+	for {
+		shard.mu.Lock()
+		if shard.hasRoom(task.Key) {
+			break
+		}
+		// Slots that are only reserved cannot be dropped, the writer waits for them instead
+		if overflow == OverflowDropOldest && len(shard.order) > 0 {
+			shard.dropOldest()
+			break
+		}
+		switch overflow {
+		case OverflowDropNewest:
+			shard.mu.Unlock()
+			w.reject(task, "drop-newest")
+			return nil
+		case OverflowFail:
+			shard.mu.Unlock()
+			w.reject(task, "fail")
+			return ErrQueueFull
+		}
+		// OverflowBlock, or OverflowDropOldest with every slot reserved
+		space := shard.space
+		shard.mu.Unlock()
+		select {
+		case <-space:
+		case <-ctx.Done():
+			w.reject(task, "block")
+			return ctx.Err()
+		case <-w.stopped():
+			w.reject(task, "block")
+			return ErrQueueClosed
+		}
+	}
+	if w.wal != nil {
+		shard.reserved++
+		shard.mu.Unlock()
+		seq, err := w.wal.Append(task)
+		if err != nil {
+			log.Printf("[WRITE QUEUE] Failed to log task for key=%s, it is not durable: %v", task.Key, err)
+		}
+		task.seq = seq
+		shard.mu.Lock()
+		shard.reserved--
+	}
+	shard.add(task)
+	if len(shard.order) >= w.batchSize {
+		select {
+		case shard.full <- struct{}{}:
+		default:
+		}
+	}
+	shard.mu.Unlock()
+	shard.cond.Signal()
+	w.updateGauges()
+	return nil
 }
 
-func (w *WriteQueue) startWorker() {
-	var timer *time.Timer
+// reject discards an incoming task that did not fit into the queue
+func (w *WriteQueue) reject(task WriteTask, policy string) {
+	w.ack(task)
+	w.metrics.overflow.WithLabelValues(policy).Inc()
+	if w.debug {
+		log.Printf("[WRITE QUEUE] Queue full, rejected task for key=%s (%s)", task.Key, policy)
+	}
+}
+
+// updateGauges publishes the queue length and saturation. Writes overflow once the share of
+// their worker is full, so the saturation is that of the fullest shard.
+func (w *WriteQueue) updateGauges() {
+	w.metrics.length.Set(float64(w.length.Load()))
+	if w.capacity <= 0 {
+		w.metrics.saturation.Set(0)
+		return
+	}
+	saturation := 0.0
+	for _, shard := range w.shards {
+		shard.mu.Lock()
+		saturation = max(saturation, float64(len(shard.order))/float64(shard.capacity))
+		shard.mu.Unlock()
+	}
+	w.metrics.saturation.Set(saturation)
+}
+
+// Cancel removes the pending task for the key and returns how many were dropped
+func (w *WriteQueue) Cancel(key string) int {
+	shard := w.shardFor(key)
+	shard.mu.Lock()
+	task, ok := shard.pending[key]
+	if !ok {
+		shard.mu.Unlock()
+		return 0
+	}
+	delete(shard.pending, key)
+	for i, pendingKey := range shard.order {
+		if pendingKey == key {
+			shard.order = append(shard.order[:i], shard.order[i+1:]...)
+			break
+		}
+	}
+	w.length.Add(-1)
+	w.ack(task)
+	shard.freed()
+	shard.advanced()
+	shard.mu.Unlock()
+
+	w.updateGauges()
+	if w.debug {
+		log.Printf("[WRITE QUEUE] Cancelled pending task for key=%s", key)
+	}
+	return 1
+}
+
+// Pending reports whether a task for the key is queued or being persisted
+func (w *WriteQueue) Pending(key string) bool {
+	shard := w.shardFor(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	_, queued := shard.pending[key]
+	_, flushing := shard.flushing[key]
+	return queued || flushing
+}
+
+// add queues the task, a pending task for the same key is replaced in place. The caller holds mu.
+func (s *writeShard) add(task WriteTask) {
+	if previous, ok := s.pending[task.Key]; ok {
+		task.pos = previous.pos // The latest value is written in place of the previous one
+		s.queue.ack(previous)
+		s.queue.metrics.coalesced.Inc()
+		if s.queue.debug {
+			log.Printf("[WRITE QUEUE] Coalesced pending task for key=%s", task.Key)
+		}
+	} else {
+		s.added++
+		task.pos = s.added
+		s.order = append(s.order, task.Key)
+		s.queue.length.Add(1)
+	}
+	s.pending[task.Key] = task
+}
+
+// requeue puts back the tasks of a batch the stopped queue did not persist, ahead of the pending
+// tasks. A key written again meanwhile keeps the newer task. The caller holds mu.
+func (s *writeShard) requeue(batch []WriteTask) {
+	keys := make([]string, 0, len(batch))
+	for _, task := range batch {
+		if _, ok := s.pending[task.Key]; ok {
+			s.queue.ack(task) // Superseded by the pending value
+			continue
+		}
+		s.pending[task.Key] = task
+		keys = append(keys, task.Key)
+	}
+	s.order = append(keys, s.order...)
+	s.queue.length.Add(int64(len(keys)))
+}
+
+// hasRoom reports whether the task can be queued without exceeding the capacity. The caller holds mu.
+func (s *writeShard) hasRoom(key string) bool {
+	if s.capacity <= 0 || len(s.order)+s.reserved < s.capacity {
+		return true
+	}
+	_, pending := s.pending[key]
+	return pending
+}
+
+// dropOldest discards the oldest pending task to make room. The caller holds mu.
+func (s *writeShard) dropOldest() {
+	key := s.order[0]
+	task := s.pending[key]
+	delete(s.pending, key)
+	s.order = s.order[1:]
+	s.queue.length.Add(-1)
+	s.queue.ack(task)
+	s.advanced()
+	s.queue.metrics.overflow.WithLabelValues("drop-oldest").Inc()
+	if s.queue.debug {
+		log.Printf("[WRITE QUEUE] Queue full, dropped oldest task for key=%s", key)
+	}
+}
+
+// freed wakes writers blocked on a full shard. The caller holds mu.
+func (s *writeShard) freed() {
+	if s.capacity <= 0 {
+		return
+	}
+	close(s.space)
+	s.space = make(chan struct{})
+}
+
+// advanced wakes Flush calls waiting for the shard. The caller holds mu.
+func (s *writeShard) advanced() {
+	close(s.progress)
+	s.progress = make(chan struct{})
+}
+
+// watermark returns the position of the oldest unfinished task, every earlier task is done.
+// Batches are taken from the front, so an in-flight batch is older than the pending tasks.
+// The caller holds mu.
+func (s *writeShard) watermark() uint64 {
+	if s.inFlight > 0 {
+		return s.inFlight
+	}
+	if len(s.order) > 0 {
+		return s.pending[s.order[0]].pos
+	}
+	return s.added + 1
+}
+
+func (s *writeShard) startWorker(run *queueRun) {
+	defer run.workers.Done()
 	for {
-		w.mu.Lock()
-		for len(w.queue) == 0 {
-			w.cond.Wait()
+		s.mu.Lock()
+		for len(s.order) == 0 && !s.stopped {
+			s.cond.Wait()
 		}
-		w.updateInterval()
-		timer = time.NewTimer(w.interval)
-		w.mu.Unlock()
+		if s.stopped {
+			s.mu.Unlock()
+			return
+		}
+		full := len(s.order) >= s.queue.batchSize || s.urgent > 0
+		s.mu.Unlock()
+
+		if !full {
+			// Linger so that a partial batch can fill up
+			timer := time.NewTimer(s.queue.maxLinger)
+			select {
+			case <-timer.C:
+			case <-s.full:
+				timer.Stop()
+			case <-run.stop:
+				timer.Stop()
+				return
+			}
+		}
+		s.flush(run)
+	}
+}
 
+// flush hands the next batch to the processor and acknowledges it
+func (s *writeShard) flush(run *queueRun) {
+	w := s.queue
+	s.mu.Lock()
+	select {
+	case <-s.full: // The batch below consumes the signal
+	default:
+	}
+	n := min(len(s.order), w.batchSize)
+	if n == 0 {
+		s.mu.Unlock()
+		return
+	}
+	batch := make([]WriteTask, 0, n)
+	for _, key := range s.order[:n] {
+		batch = append(batch, s.pending[key])
+		delete(s.pending, key)
+		s.flushing[key] = struct{}{}
+	}
+	s.order = s.order[n:]
+	s.inFlight = batch[0].pos
+	w.length.Add(-int64(n))
+	s.freed()
+	s.mu.Unlock()
+	w.updateGauges()
+
+	if w.debug {
+		log.Printf("[WRITE QUEUE] Flushing batch of %d task(s)", len(batch))
+	}
+	startTime := time.Now()
+	attempts, failed, err := w.process(run, batch)
+	if errors.Is(err, errQueueStopped) {
+		// Not persisted: the failed tasks are queued again for the next Start
+		requeued := make(map[uint64]struct{}, len(failed))
+		for _, task := range failed {
+			requeued[task.pos] = struct{}{}
+		}
+		for _, task := range batch {
+			if _, ok := requeued[task.pos]; !ok {
+				w.ack(task) // Persisted by a partial write
+			}
+		}
+		s.mu.Lock()
+		s.requeue(failed)
+		s.inFlight = 0
+		clear(s.flushing)
+		s.advanced()
+		s.mu.Unlock()
+		return
+	}
+	if err != nil {
+		w.deadLetter(failed, err, attempts)
+	}
+	for _, task := range batch {
+		w.ack(task)
+	}
+	s.mu.Lock()
+	if s.urgent > 0 {
+		// Flush reports the tasks it waited for that were not persisted
+		for _, task := range failed {
+			s.failed = append(s.failed, task.pos)
+		}
+	}
+	s.inFlight = 0
+	clear(s.flushing)
+	s.advanced()
+	s.mu.Unlock()
+	w.metrics.processingTime.Observe(time.Since(startTime).Seconds())
+	w.metrics.batchSize.Observe(float64(len(batch)))
+	w.metrics.processed.Add(float64(len(batch)))
+}
+
+var (
+	// ErrQueueClosed is returned by writes that arrive after the queue was shut down
+	ErrQueueClosed = errors.New("write queue is closed")
+
+	// ErrDeadLettered is returned by Flush when tasks it waited for failed every attempt
+	ErrDeadLettered = errors.New("tasks were dead-lettered")
+
+	errQueueStopped = errors.New("write queue stopped")
+)
+
+// process runs the processor, retrying a failed batch with backoff until the retry policy gives up.
+// After a PartialWriteError only the failed tasks are retried. It returns the tasks left unpersisted.
+func (w *WriteQueue) process(run *queueRun, batch []WriteTask) (int, []WriteTask, error) {
+	for attempt := 1; ; attempt++ {
+		err := w.processor(run.ctx, batch)
+		if err == nil {
+			return attempt, nil, nil
+		}
+		var partial *PartialWriteError
+		if errors.As(err, &partial) {
+			if failed := partial.failedTasks(batch); len(failed) > 0 {
+				batch = failed
+			}
+		}
+		if attempt >= w.retry.MaxAttempts {
+			return attempt, batch, err
+		}
+		delay := w.retry.backoff(attempt)
+		w.metrics.retries.Inc()
+		log.Printf("[WRITE QUEUE] Failed to persist batch of %d task(s), attempt %d/%d, retrying in %v: %v",
+			len(batch), attempt, w.retry.MaxAttempts, delay, err)
+		timer := time.NewTimer(delay)
 		select {
 		case <-timer.C:
-			w.mu.Lock()
-			if len(w.queue) == 0 {
-				w.mu.Unlock()
-				continue
-			}
-			task := w.queue[0]
-			w.queue = w.queue[1:]
-			w.updateInterval()
-			w.mu.Unlock()
-			startTime := time.Now()
-			w.processor(task)
-			taskProcessingHistogram.Observe(time.Since(startTime).Seconds())
-			processedTasksCounter.Inc()
-			queueLengthGauge.Set(float64(len(w.queue)))
-		case <-w.stopChan:
+		case <-run.stop:
 			timer.Stop()
-			return
+			return attempt, batch, errQueueStopped
 		}
 	}
 }
 
-func (w *WriteQueue) updateInterval() {
-	queueLen := len(w.queue)
-	switch {
-	case queueLen > 10:
-		w.interval = 80 * time.Millisecond
-	case queueLen > 7:
-		w.interval = 200 * time.Millisecond
-	case queueLen > 4:
-		w.interval = 400 * time.Millisecond
-	default:
-		w.interval = 600 * time.Millisecond
+// deadLetter hands the tasks of a batch that failed every attempt to the sink
+func (w *WriteQueue) deadLetter(batch []WriteTask, err error, attempts int) {
+	log.Printf("[WRITE QUEUE] Giving up on batch of %d task(s) after %d attempt(s): %v", len(batch), attempts, err)
+	now := time.Now()
+	var partial *PartialWriteError
+	errors.As(err, &partial)
+	for _, task := range batch {
+		task.seq = 0
+		taskErr := err
+		if partial != nil && partial.Failed[task.Key] != nil {
+			taskErr = partial.Failed[task.Key]
+		}
+		letter := DeadLetter{Task: task, Error: taskErr.Error(), Attempts: attempts, FailedAt: now}
+		if putErr := w.deadLetters.Put(letter); putErr != nil {
+			log.Printf("[WRITE QUEUE] Failed to dead-letter task for key=%s, it is lost: %v", task.Key, putErr)
+			continue
+		}
+		w.metrics.deadLettered.Inc()
+	}
+}
+
+// DeadLetters lists the tasks that could not be persisted
+func (w *WriteQueue) DeadLetters() ([]DeadLetter, error) {
+	return w.deadLetters.List()
+}
+
+// RequeueDeadLetters moves the dead letters of the keys (all of them when no key is given) back
+// into the queue and returns how many were requeued. A key with a queued or in-flight write keeps
+// the newer value, so does a key the superseded check (WithSuperseded) reports as written since.
+func (w *WriteQueue) RequeueDeadLetters(ctx context.Context, keys ...string) (int, error) {
+	letters, err := w.deadLetters.Take(keys...)
+	if err != nil {
+		return 0, err
+	}
+	requeued := 0
+	for i, letter := range letters {
+		if w.Pending(letter.Task.Key) || (w.superseded != nil && w.superseded(ctx, letter.Task)) {
+			if w.debug {
+				log.Printf("[WRITE QUEUE] Dropped superseded dead letter for key=%s", letter.Task.Key)
+			}
+			continue
+		}
+		if err = w.Enqueue(ctx, letter.Task); err != nil {
+			// Letters that did not make it into the queue go back to the sink
+			for _, rest := range letters[i:] {
+				if putErr := w.deadLetters.Put(rest); putErr != nil {
+					log.Printf("[WRITE QUEUE] Failed to restore dead letter for key=%s: %v", rest.Task.Key, putErr)
+				}
+			}
+			return requeued, err
+		}
+		requeued++
+	}
+	if w.debug {
+		log.Printf("[WRITE QUEUE] Requeued %d of %d dead letter(s)", requeued, len(letters))
+	}
+	return requeued, nil
+}
+
+// ack removes a finished, superseded or cancelled task from the write-ahead log
+func (w *WriteQueue) ack(task WriteTask) {
+	if w.wal == nil {
+		return
+	}
+	if err := w.wal.Ack(task.seq); err != nil {
+		log.Printf("[WRITE QUEUE] Failed to acknowledge task for key=%s: %v", task.Key, err)
 	}
 }
 
+// Flush blocks until every task enqueued before the call is persisted or dead-lettered.
+// Partial batches are flushed without lingering while it runs. It returns ErrDeadLettered
+// when some of those tasks were dead-lettered instead of persisted.
+func (w *WriteQueue) Flush(ctx context.Context) error {
+	_, err := w.flush(ctx)
+	return err
+}
+
+// flush implements Flush and returns the number of dead-lettered tasks
+func (w *WriteQueue) flush(ctx context.Context) (int, error) {
+	floors := make([]uint64, len(w.shards))
+	targets := make([]uint64, len(w.shards))
+	for i, shard := range w.shards {
+		shard.mu.Lock()
+		floors[i] = shard.watermark()
+		targets[i] = shard.added
+		shard.urgent++
+		shard.mu.Unlock()
+		select {
+		case shard.full <- struct{}{}: // Cut the current linger short
+		default:
+		}
+	}
+	defer func() {
+		for _, shard := range w.shards {
+			shard.mu.Lock()
+			shard.urgent--
+			if shard.urgent == 0 {
+				shard.failed = nil
+			}
+			shard.mu.Unlock()
+		}
+	}()
+
+	deadLettered := 0
+	for i, shard := range w.shards {
+		for {
+			shard.mu.Lock()
+			if shard.watermark() > targets[i] {
+				for _, pos := range shard.failed {
+					if pos >= floors[i] && pos <= targets[i] {
+						deadLettered++
+					}
+				}
+				shard.mu.Unlock()
+				break
+			}
+			progress := shard.progress
+			shard.mu.Unlock()
+			select {
+			case <-progress:
+			case <-ctx.Done():
+				return deadLettered, ctx.Err()
+			case <-w.stopped():
+				return deadLettered, ErrQueueClosed
+			}
+		}
+	}
+	if deadLettered > 0 {
+		return deadLettered, fmt.Errorf("%w: %d task(s)", ErrDeadLettered, deadLettered)
+	}
+	return 0, nil
+}
+
+// awaitKey blocks until no batch holding the key is being persisted
+func (w *WriteQueue) awaitKey(ctx context.Context, key string) error {
+	shard := w.shardFor(key)
+	for {
+		shard.mu.Lock()
+		_, flushing := shard.flushing[key]
+		progress := shard.progress
+		shard.mu.Unlock()
+		if !flushing {
+			return nil
+		}
+		select {
+		case <-progress:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-w.stopped():
+			return ErrQueueClosed
+		}
+	}
+}
+
+// Shutdown stops accepting writes, drains the queue until ctx is done, stops the workers and
+// closes the write-ahead log. When ctx ends first the processor context is cancelled and Shutdown
+// returns without waiting for the batches still being processed. It returns the number of tasks
+// left unpersisted, dead-lettered ones included. Dead-lettered tasks are handed to the dead-letter
+// sink and acknowledged, so they leave the write-ahead log; the others stay in it if there is one.
+func (w *WriteQueue) Shutdown(ctx context.Context) (int, error) {
+	w.closed.Store(true)
+	deadLettered, err := w.flush(ctx)
+	run := w.run.Load()
+	stopped := make(chan struct{})
+	go func() {
+		w.Stop()
+		if w.wal != nil {
+			if err := w.wal.Close(); err != nil {
+				log.Printf("[WRITE QUEUE] Failed to close the write-ahead log: %v", err)
+			}
+		}
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		run.cancel()
+		if err == nil {
+			err = ctx.Err()
+		}
+	}
+	unpersisted := int(w.length.Load()) + w.inFlightTasks() + deadLettered
+	if unpersisted > 0 {
+		log.Printf("[WRITE QUEUE] Shut down with %d unpersisted task(s)", unpersisted)
+	} else if w.debug {
+		log.Printf("[WRITE QUEUE] Shut down, queue drained")
+	}
+	return unpersisted, err
+}
+
+// inFlightTasks returns the number of tasks in batches still being processed
+func (w *WriteQueue) inFlightTasks() int {
+	n := 0
+	for _, shard := range w.shards {
+		shard.mu.Lock()
+		n += len(shard.flushing)
+		shard.mu.Unlock()
+	}
+	return n
+}
+
+// Stop stops the workers once the batches being processed are done, Start runs them again.
+// Pending tasks stay queued, and in the write-ahead log until Shutdown closes it. A batch waiting
+// for a retry is queued again.
 func (w *WriteQueue) Stop() {
-	close(w.stopChan)
+	w.stop(w.run.Load())
+}
+
+// stop ends the run unless it already ended
+func (w *WriteQueue) stop(run *queueRun) {
+	w.runMu.Lock()
+	defer w.runMu.Unlock()
+	if run.stopped {
+		return
+	}
+	run.stopped = true
+	for _, shard := range w.shards {
+		shard.mu.Lock()
+		shard.stopped = true
+		shard.mu.Unlock()
+		shard.cond.Broadcast()
+	}
+	close(run.stop)
+	run.workers.Wait()
+	run.cancel()
 }
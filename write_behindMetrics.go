@@ -1,27 +1,60 @@
 package multi_tier_caching
 
-import "github.com/prometheus/client_golang/prometheus"
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type writeQueueMetrics struct {
+	length         prometheus.Gauge
+	saturation     prometheus.Gauge
+	overflow       *prometheus.CounterVec
+	processed      prometheus.Counter
+	processingTime prometheus.Histogram
+	batchSize      prometheus.Histogram
+	retries        prometheus.Counter
+	deadLettered   prometheus.Counter
+	coalesced      prometheus.Counter
+}
 
-var (
-	queueLengthGauge = prometheus.NewGauge(
-		prometheus.GaugeOpts{
+func newWriteQueueMetrics(metrics MetricsConfig) *writeQueueMetrics {
+	return &writeQueueMetrics{
+		length: metrics.Gauge(prometheus.GaugeOpts{
 			Name: "write_queue_length",
 			Help: "Current number of tasks in the write queue",
-		},
-	)
-
-	processedTasksCounter = prometheus.NewCounter(
-		prometheus.CounterOpts{
+		}),
+		saturation: metrics.Gauge(prometheus.GaugeOpts{
+			Name: "write_queue_saturation",
+			Help: "Length of the fullest write queue shard as a fraction of its capacity, 0 for an unbounded queue",
+		}),
+		overflow: metrics.CounterVec(prometheus.CounterOpts{
+			Name: "write_queue_overflow_total",
+			Help: "Total number of tasks dropped or rejected because the write queue was full",
+		}, []string{"policy"}),
+		processed: metrics.Counter(prometheus.CounterOpts{
 			Name: "write_queue_processed_total",
 			Help: "Total number of processed tasks in the write queue",
-		},
-	)
-
-	taskProcessingHistogram = prometheus.NewHistogram(
-		prometheus.HistogramOpts{
+		}),
+		processingTime: metrics.Histogram(prometheus.HistogramOpts{
 			Name:    "write_queue_processing_time_seconds",
-			Help:    "Histogram of write task processing times",
+			Help:    "Histogram of write batch processing times",
 			Buckets: prometheus.LinearBuckets(0.01, 0.05, 10), // от 10ms до 500ms
-		},
-	)
-)
+		}),
+		batchSize: metrics.Histogram(prometheus.HistogramOpts{
+			Name:    "write_queue_batch_size",
+			Help:    "Histogram of the number of tasks per flushed batch",
+			Buckets: prometheus.ExponentialBuckets(1, 2, 10), // от 1 до 512
+		}),
+		retries: metrics.Counter(prometheus.CounterOpts{
+			Name: "write_queue_retries_total",
+			Help: "Total number of retried write batches",
+		}),
+		deadLettered: metrics.Counter(prometheus.CounterOpts{
+			Name: "write_queue_dead_lettered_total",
+			Help: "Total number of tasks moved to the dead letter sink",
+		}),
+		coalesced: metrics.Counter(prometheus.CounterOpts{
+			Name: "write_queue_coalesced_total",
+			Help: "Total number of pending tasks replaced by a newer value for the same key",
+		}),
+	}
+}
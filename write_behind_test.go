@@ -1,30 +1,38 @@
 package multi_tier_caching
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestWriteQueue(t *testing.T) {
 	var processedTasks []WriteTask
 	var mu sync.Mutex
 
-	processor := func(task WriteTask) {
+	processor := func(_ context.Context, tasks []WriteTask) error {
 		mu.Lock()
-		processedTasks = append(processedTasks, task)
+		processedTasks = append(processedTasks, tasks...)
 		mu.Unlock()
+		return nil
 	}
 
-	wq := NewWriteQueue(processor, false)
+	wq := NewWriteQueue(processor, false, withTestMetrics())
 
 	task1 := WriteTask{Key: "key1", Value: "value1"}
 	task2 := WriteTask{Key: "key2", Value: "value2"}
 
-	wq.Enqueue(task1)
-	wq.Enqueue(task2)
+	assert.NoError(t, wq.Enqueue(context.Background(), task1))
+	assert.NoError(t, wq.Enqueue(context.Background(), task2))
 
 	// Wait for the queue to process tasks
 	time.Sleep(2 * time.Second)
@@ -38,3 +46,366 @@ func TestWriteQueue(t *testing.T) {
 	assert.Equal(t, "key2", processedTasks[1].Key, "The second task must be key2")
 	assert.Equal(t, "value2", processedTasks[1].Value, "The second task must contain value2")
 }
+
+func TestWriteQueue_Coalescing(t *testing.T) {
+	var batches [][]WriteTask
+	var mu sync.Mutex
+
+	wq := NewWriteQueue(func(_ context.Context, tasks []WriteTask) error {
+		mu.Lock()
+		batches = append(batches, tasks)
+		mu.Unlock()
+		return nil
+	}, false, withTestMetrics(), WithMaxLinger(200*time.Millisecond))
+	defer wq.Stop()
+
+	assert.NoError(t, wq.Enqueue(context.Background(), WriteTask{Key: "key1", Value: "value1"}))
+	assert.NoError(t, wq.Enqueue(context.Background(), WriteTask{Key: "key2", Value: "value2"}))
+	assert.NoError(t, wq.Enqueue(context.Background(), WriteTask{Key: "key1", Value: "value3"}))
+
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(batches) == 1
+	}, 2*time.Second, 20*time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []WriteTask{
+		{Key: "key1", Value: "value3", pos: 1},
+		{Key: "key2", Value: "value2", pos: 2},
+	}, batches[0], "Only the latest value of key1 must be written, at its first position")
+}
+
+func TestWriteQueue_FullBatchFlushesImmediately(t *testing.T) {
+	var batches [][]WriteTask
+	var mu sync.Mutex
+
+	wq := NewWriteQueue(func(_ context.Context, tasks []WriteTask) error {
+		mu.Lock()
+		batches = append(batches, tasks)
+		mu.Unlock()
+		return nil
+	}, false, withTestMetrics(), WithBatchSize(3), WithMaxLinger(time.Hour))
+	defer wq.Stop()
+
+	for _, key := range []string{"key1", "key2", "key3", "key4"} {
+		assert.NoError(t, wq.Enqueue(context.Background(), WriteTask{Key: key, Value: "value"}))
+	}
+
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(batches) == 1
+	}, 2*time.Second, 20*time.Millisecond, "A full batch must not wait for the linger time")
+
+	time.Sleep(100 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Len(t, batches, 1, "The partial batch must linger")
+	assert.Len(t, batches[0], 3)
+}
+
+func TestWriteQueue_OverflowPolicies(t *testing.T) {
+	pendingKeys := func(wq *WriteQueue) []string {
+		shard := wq.shards[0]
+		shard.mu.Lock()
+		defer shard.mu.Unlock()
+		return append([]string(nil), shard.order...)
+	}
+	newQueue := func(policy OverflowPolicy) *WriteQueue {
+		// Nothing is flushed during the test, so the queue stays full
+		wq := NewWriteQueue(func(_ context.Context, tasks []WriteTask) error { return nil }, false,
+			withTestMetrics(), WithMaxLinger(time.Hour), WithCapacity(2, policy))
+		t.Cleanup(wq.Stop)
+		ctx := context.Background()
+		assert.NoError(t, wq.Enqueue(ctx, WriteTask{Key: "key1", Value: "value1"}))
+		assert.NoError(t, wq.Enqueue(ctx, WriteTask{Key: "key2", Value: "value2"}))
+		return wq
+	}
+
+	t.Run("fail", func(t *testing.T) {
+		wq := newQueue(OverflowFail)
+		err := wq.Enqueue(context.Background(), WriteTask{Key: "key3", Value: "value3"})
+		assert.ErrorIs(t, err, ErrQueueFull)
+		assert.NoError(t, wq.Enqueue(context.Background(), WriteTask{Key: "key1", Value: "value4"}),
+			"A pending key must be replaced even when the queue is full")
+		assert.Equal(t, []string{"key1", "key2"}, pendingKeys(wq))
+	})
+
+	t.Run("drop-newest", func(t *testing.T) {
+		wq := newQueue(OverflowDropNewest)
+		assert.NoError(t, wq.Enqueue(context.Background(), WriteTask{Key: "key3", Value: "value3"}))
+		assert.Equal(t, []string{"key1", "key2"}, pendingKeys(wq))
+	})
+
+	t.Run("drop-oldest", func(t *testing.T) {
+		wq := newQueue(OverflowDropOldest)
+		assert.NoError(t, wq.Enqueue(context.Background(), WriteTask{Key: "key3", Value: "value3"}))
+		assert.Equal(t, []string{"key2", "key3"}, pendingKeys(wq))
+	})
+
+	t.Run("block", func(t *testing.T) {
+		wq := newQueue(OverflowBlock)
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		err := wq.Enqueue(ctx, WriteTask{Key: "key3", Value: "value3"})
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+		done := make(chan error, 1)
+		go func() {
+			done <- wq.Enqueue(context.Background(), WriteTask{Key: "key3", Value: "value3"})
+		}()
+		time.Sleep(20 * time.Millisecond)
+		wq.Cancel("key1")
+		select {
+		case err = <-done:
+			assert.NoError(t, err)
+		case <-time.After(2 * time.Second):
+			t.Fatal("Enqueue must resume once space is freed")
+		}
+		assert.Equal(t, []string{"key2", "key3"}, pendingKeys(wq))
+	})
+}
+
+func TestWriteQueue_ShardedWorkers(t *testing.T) {
+	var (
+		mu        sync.Mutex
+		inFlight  int
+		maxFlight int
+		persisted = make(map[string]string)
+	)
+	wq := NewWriteQueue(func(_ context.Context, tasks []WriteTask) error {
+		mu.Lock()
+		inFlight++
+		maxFlight = max(maxFlight, inFlight)
+		mu.Unlock()
+		time.Sleep(50 * time.Millisecond)
+		mu.Lock()
+		defer mu.Unlock()
+		inFlight--
+		for _, task := range tasks {
+			persisted[task.Key] = task.Value
+		}
+		return nil
+	}, false, withTestMetrics(), WithWorkers(4), WithBatchSize(1), WithMaxLinger(time.Millisecond))
+	defer wq.Stop()
+
+	keys := []string{"key1", "key2", "key3", "key4", "key5", "key6", "key7", "key8"}
+	assert.Same(t, wq.shardFor("key1"), wq.shardFor("key1"), "A key must always map to the same worker")
+	for round := 0; round < 3; round++ {
+		for _, key := range keys {
+			assert.NoError(t, wq.Enqueue(context.Background(), WriteTask{Key: key, Value: key + "-" + string(rune('a'+round))}))
+		}
+	}
+
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, key := range keys {
+			if persisted[key] != key+"-c" {
+				return false
+			}
+		}
+		return true
+	}, 5*time.Second, 20*time.Millisecond, "The last value of every key must win")
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Greater(t, maxFlight, 1, "Different keys must be persisted concurrently")
+}
+
+func TestWriteQueue_ShardedCapacity(t *testing.T) {
+	newQueue := func(workers, capacity int) *WriteQueue {
+		wq := NewWriteQueue(func(_ context.Context, tasks []WriteTask) error { return nil }, false,
+			WithWorkers(workers), WithMaxLinger(time.Hour), WithCapacity(capacity, OverflowFail),
+			withTestMetrics())
+		t.Cleanup(wq.Stop)
+		return wq
+	}
+	capacities := func(wq *WriteQueue) []int {
+		var shares []int
+		for _, shard := range wq.shards {
+			shares = append(shares, shard.capacity)
+		}
+		return shares
+	}
+
+	// The shares add up to the capacity
+	assert.Equal(t, []int{4, 3, 3}, capacities(newQueue(3, 10)))
+	assert.Equal(t, []int{1, 1}, capacities(newQueue(4, 2)), "Every worker needs a slot")
+
+	wq := newQueue(2, 4)
+	accepted := 0
+	for i := 0; i < 20; i++ {
+		if wq.Enqueue(context.Background(), WriteTask{Key: fmt.Sprintf("key%d", i)}) == nil {
+			accepted++
+		}
+	}
+	assert.Equal(t, 4, accepted, "The queue must never hold more than its capacity")
+	assert.Equal(t, 1.0, testutil.ToFloat64(wq.metrics.saturation))
+}
+
+func TestWriteQueue_FlushAndShutdown(t *testing.T) {
+	var persisted []string
+	var mu sync.Mutex
+	release := make(chan struct{})
+
+	wq := NewWriteQueue(func(_ context.Context, tasks []WriteTask) error {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, task := range tasks {
+			if task.Key == "slow" {
+				mu.Unlock()
+				<-release
+				mu.Lock()
+			}
+			persisted = append(persisted, task.Key)
+		}
+		return nil
+	}, false, withTestMetrics(), WithBatchSize(1), WithMaxLinger(time.Hour))
+
+	ctx := context.Background()
+	assert.NoError(t, wq.Enqueue(ctx, WriteTask{Key: "key1", Value: "value1"}))
+	assert.NoError(t, wq.Enqueue(ctx, WriteTask{Key: "key2", Value: "value2"}))
+
+	flushCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	assert.NoError(t, wq.Flush(flushCtx), "Flush must not wait for the linger time")
+	mu.Lock()
+	assert.Equal(t, []string{"key1", "key2"}, persisted)
+	mu.Unlock()
+
+	// The slow task blocks the worker, Shutdown gives up on it once the deadline expires
+	assert.NoError(t, wq.Enqueue(ctx, WriteTask{Key: "slow", Value: "value"}))
+	assert.NoError(t, wq.Enqueue(ctx, WriteTask{Key: "key3", Value: "value3"}))
+	t.Cleanup(func() { close(release) })
+	shutdownCtx, cancelShutdown := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancelShutdown()
+	start := time.Now()
+	unpersisted, err := wq.Shutdown(shutdownCtx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second, "Shutdown must not wait for the in-flight batch")
+	assert.Equal(t, 2, unpersisted, "The in-flight task and key3 must be reported as unpersisted")
+	assert.ErrorIs(t, wq.run.Load().ctx.Err(), context.Canceled, "The processor context must be cancelled")
+	assert.ErrorIs(t, wq.Enqueue(ctx, WriteTask{Key: "key4", Value: "value4"}), ErrQueueClosed)
+}
+
+func TestWriteQueue_Restart(t *testing.T) {
+	var processed atomic.Int32
+	wq := NewWriteQueue(func(_ context.Context, tasks []WriteTask) error {
+		processed.Add(int32(len(tasks)))
+		return nil
+	}, false, withTestMetrics(), WithMaxLinger(time.Millisecond))
+	ctx := context.Background()
+
+	// A stopped queue keeps its tasks until it is started again
+	wq.Stop()
+	assert.NoError(t, wq.Enqueue(ctx, WriteTask{Key: "key1", Value: "value1"}))
+	time.Sleep(20 * time.Millisecond)
+	assert.Zero(t, processed.Load())
+	wq.Start(ctx)
+	require.NoError(t, wq.Flush(ctx))
+	assert.Equal(t, int32(1), processed.Load())
+
+	// The workers stop with the context of Start
+	wq.Stop()
+	runCtx, cancel := context.WithCancel(ctx)
+	wq.Start(runCtx)
+	cancel()
+	select {
+	case <-wq.stopped():
+	case <-time.After(2 * time.Second):
+		t.Fatal("The workers must stop once the context of Start is done")
+	}
+
+	wq.Start(ctx)
+	_, err := wq.Shutdown(ctx)
+	require.NoError(t, err)
+	wq.Start(ctx)
+	select {
+	case <-wq.stopped():
+	default:
+		t.Fatal("A shut down queue must not start again")
+	}
+}
+
+func TestWriteQueue_StopRequeuesRetriedBatch(t *testing.T) {
+	var fail atomic.Bool
+	fail.Store(true)
+	attempted := make(chan struct{}, 1)
+	var processed atomic.Int32
+	wq := NewWriteQueue(func(_ context.Context, tasks []WriteTask) error {
+		if fail.Load() {
+			select {
+			case attempted <- struct{}{}:
+			default:
+			}
+			return errors.New("connection refused")
+		}
+		processed.Add(int32(len(tasks)))
+		return nil
+	}, false, withTestMetrics(), WithMaxLinger(time.Millisecond),
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 5, BaseDelay: time.Hour, MaxDelay: time.Hour}))
+	ctx := context.Background()
+
+	// The batch waits for its retry when the queue stops
+	require.NoError(t, wq.Enqueue(ctx, WriteTask{Key: "key1", Value: "value1"}))
+	<-attempted
+	wq.Stop()
+	assert.True(t, wq.Pending("key1"), "a batch interrupted by Stop is queued again")
+
+	fail.Store(false)
+	wq.Start(ctx)
+	require.NoError(t, wq.Flush(ctx))
+	assert.Equal(t, int32(1), processed.Load())
+	unpersisted, err := wq.Shutdown(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, unpersisted)
+}
+
+func TestMultiTierCache_WriteBehindOverflow(t *testing.T) {
+	ctx := context.Background()
+	layer := newBatchTestStore("memory", nil)
+	db := newBatchTestStore("db", map[string]string{"stored": "value"})
+	newCache := func(policy OverflowPolicy) *MultiTierCache {
+		return newTestCache(t, MultiTierCacheConfig{
+			Layers:     []LayerInfo{NewLayerInfo(layer)},
+			DB:         db,
+			Thresholds: []int{0},
+
+			WALDir:             t.TempDir(),
+			WriteMaxLinger:     time.Hour, // Nothing is flushed, the queue stays full
+			WriteQueueCapacity: 1,
+			WriteOverflow:      policy,
+		})
+	}
+
+	cache := newCache(OverflowFail)
+	require.NoError(t, cache.Set(ctx, "key1", "value1"))
+	assert.ErrorIs(t, cache.Set(ctx, "key2", "value2"), ErrQueueFull)
+	_, ok := layer.value("key2")
+	assert.False(t, ok, "A rejected write must leave the cache untouched")
+	assert.Len(t, cache.writeQueue.wal.Pending(), 1, "A rejected write must not be logged")
+
+	// The read path skips the queue write instead of waiting for space
+	cache = newCache(OverflowBlock)
+	require.NoError(t, cache.Set(ctx, "key1", "value1"))
+	cache.filter.Add("stored")
+	done := make(chan error, 1)
+	go func() {
+		_, err := cache.Get(ctx, "stored")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("Get must not block on a full write queue")
+	}
+}
+
+// withTestMetrics registers the queue metrics with a registry of their own
+func withTestMetrics() WriteQueueOption {
+	return WithMetrics(MetricsConfig{Registerer: prometheus.NewRegistry()})
+}
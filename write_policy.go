@@ -0,0 +1,119 @@
+package multi_tier_caching
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// ErrLayerWrite is returned by write-through when the database accepted the value but a cache layer
+// did not. The key is removed from the layers, so the next read loads the persisted value.
+var ErrLayerWrite = errors.New("cache layer write failed")
+
+// WritePolicy selects how Set persists values
+type WritePolicy int
+
+const (
+	// WriteBehind queues the database write and then writes the cache layers. A write the queue
+	// rejects (see OverflowPolicy) fails Set before the cache is touched, a later layer error is
+	// wrapped in ErrLayerWrite. Queued writes are lost if the process crashes, see WALDir.
+	WriteBehind WritePolicy = iota
+	// WriteThrough writes the database synchronously and then the cache layers.
+	// A database error is returned before the cache is touched, a later layer error is wrapped in ErrLayerWrite.
+	WriteThrough
+	// WriteAround writes the database synchronously and invalidates the key in every cache layer.
+	// A database error is returned and the cache is left unchanged.
+	WriteAround
+)
+
+func (p WritePolicy) String() string {
+	switch p {
+	case WriteThrough:
+		return "write-through"
+	case WriteAround:
+		return "write-around"
+	default:
+		return "write-behind"
+	}
+}
+
+// SetWithPolicy stores the value using the given policy instead of the configured one
+func (c *MultiTierCache) SetWithPolicy(ctx context.Context, key, value string, policy WritePolicy) error {
+	_, _, err := c.write(ctx, key, value, policy)
+	return err
+}
+
+// write stores the value using the policy and returns its TTL and whether it was written
+func (c *MultiTierCache) write(ctx context.Context, key, value string, policy WritePolicy) (time.Duration, bool, error) {
+	switch policy {
+	case WriteBehind:
+		return c.setWriteBehind(ctx, key, value)
+	case WriteThrough:
+		return c.setWriteThrough(ctx, key, value)
+	case WriteAround:
+		return c.setWriteAround(ctx, key, value)
+	default:
+		return 0, false, fmt.Errorf("unknown write policy %d", policy)
+	}
+}
+
+func (c *MultiTierCache) setWriteThrough(ctx context.Context, key, value string) (time.Duration, bool, error) {
+	ttl, freq, place := c.writePlan(ctx, key)
+	if err := c.persistNow(ctx, key, value, ttl); err != nil {
+		return ttl, false, err
+	}
+	c.clearNegative(ctx, key)
+	c.filter.Add(key)
+
+	if !place {
+		// The layers keep their TTL, drop the old copies instead of serving them
+		c.invalidateLayers(ctx, key)
+		return ttl, true, nil
+	}
+	for _, layerInfo := range c.selectTargetLayers(freq) {
+		if err := c.writeLayer(ctx, layerInfo.Layer, key, value, ttl); err != nil {
+			log.Printf("[CACHE] Write-through of key=%s to layer %v failed: %v", key, layerInfo.Name, err)
+			c.invalidateLayers(ctx, key)
+			return ttl, true, fmt.Errorf("%w: %s: %v", ErrLayerWrite, layerInfo.Name, err)
+		}
+	}
+	c.ttlManager.AdjustTTL(key, int64(ttl/time.Second))
+	return ttl, true, nil
+}
+
+func (c *MultiTierCache) setWriteAround(ctx context.Context, key, value string) (time.Duration, bool, error) {
+	ttl, _, _ := c.writePlan(ctx, key)
+	if err := c.persistNow(ctx, key, value, ttl); err != nil {
+		return ttl, false, err
+	}
+	c.invalidateLayers(ctx, key)
+	c.filter.Add(key)
+	return ttl, true, nil
+}
+
+// persistNow synchronously writes the value to the database. Pending write-behind tasks
+// for the key are cancelled and a batch already persisting it is awaited first, so an older
+// queued value cannot overwrite this one.
+func (c *MultiTierCache) persistNow(ctx context.Context, key, value string, ttl time.Duration) error {
+	c.writeQueue.Cancel(key)
+	if err := c.writeQueue.awaitKey(ctx, key); err != nil {
+		return fmt.Errorf("database write key=%s: %w", key, err)
+	}
+	if err := c.persist(ctx, c.newWriteTask(key, value, ttl)); err != nil {
+		return fmt.Errorf("database write key=%s: %w", key, err)
+	}
+	if c.debug {
+		log.Printf("[CACHE] Persisted key=%s synchronously, TTL=%v", key, ttl)
+	}
+	return nil
+}
+
+// invalidateLayers removes the key from every cache layer and forgets its TTL
+func (c *MultiTierCache) invalidateLayers(ctx context.Context, key string) {
+	for _, layer := range c.layers {
+		layer.Layer.Delete(ctx, key)
+	}
+	c.ttlManager.RemoveTTL(key)
+}
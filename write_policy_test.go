@@ -0,0 +1,107 @@
+package multi_tier_caching
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	databaseMock "github.com/arturmon/multi-tier-caching/storage/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func newWritePolicyTestCache(t *testing.T, db Database, policy WritePolicy) (*MultiTierCache, *batchTestStore) {
+	t.Helper()
+	layer := newBatchTestStore("memory", nil)
+	cache := newTestCache(t, MultiTierCacheConfig{
+		Layers:      []LayerInfo{NewLayerInfo(layer)},
+		DB:          db,
+		Thresholds:  []int{0},
+		WritePolicy: policy,
+	})
+	return cache, layer
+}
+
+func TestMultiTierCache_WriteThrough(t *testing.T) {
+	ctx := context.Background()
+	db := newBatchTestStore("db", nil)
+	cache, layer := newWritePolicyTestCache(t, db, WriteThrough)
+
+	require.NoError(t, cache.Set(ctx, "key1", "value1"))
+	value, ok := db.value("key1")
+	assert.True(t, ok, "Write-through must persist before returning")
+	assert.Equal(t, "value1", value)
+	value, ok = layer.value("key1")
+	assert.True(t, ok)
+	assert.Equal(t, "value1", value)
+	assert.True(t, cache.filter.Exists("key1"))
+}
+
+func TestMultiTierCache_WriteThrough_DatabaseError(t *testing.T) {
+	ctx := context.Background()
+	db := new(databaseMock.MockDatabaseStorage)
+	db.On("Set", mock.Anything, "key1", "value1").Return(errors.New("connection refused"))
+	cache, layer := newWritePolicyTestCache(t, db, WriteThrough)
+
+	err := cache.Set(ctx, "key1", "value1")
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, ErrLayerWrite)
+	_, ok := layer.value("key1")
+	assert.False(t, ok, "The cache must not be touched when the database write fails")
+}
+
+func TestMultiTierCache_WriteAround(t *testing.T) {
+	ctx := context.Background()
+	db := newBatchTestStore("db", nil)
+	cache, layer := newWritePolicyTestCache(t, db, WriteBehind)
+	require.NoError(t, layer.Set(ctx, "key1", "old", 0))
+
+	require.NoError(t, cache.SetWithPolicy(ctx, "key1", "new", WriteAround))
+	value, ok := db.value("key1")
+	assert.True(t, ok)
+	assert.Equal(t, "new", value)
+	_, ok = layer.value("key1")
+	assert.False(t, ok, "Write-around must invalidate the cache layers")
+}
+
+// slowBatchStore is a database whose batch writes wait until release is closed
+type slowBatchStore struct {
+	*batchTestStore
+	started chan struct{}
+	release chan struct{}
+}
+
+func (s *slowBatchStore) MSet(ctx context.Context, items map[string]string, ttl time.Duration) error {
+	close(s.started)
+	<-s.release
+	return s.batchTestStore.MSet(ctx, items, ttl)
+}
+
+func TestMultiTierCache_WriteThrough_InFlightBatch(t *testing.T) {
+	ctx := context.Background()
+	db := &slowBatchStore{batchTestStore: newBatchTestStore("db", nil), started: make(chan struct{}), release: make(chan struct{})}
+	cache, _ := newWritePolicyTestCache(t, db, WriteBehind)
+	release := sync.OnceFunc(func() { close(db.release) })
+	t.Cleanup(release) // Runs before the queue stops, so a failing test does not hang
+
+	// A worker takes the queued value and is persisting it
+	require.NoError(t, cache.Set(ctx, "key1", "old"))
+	go func() { _ = cache.writeQueue.Flush(ctx) }()
+	<-db.started
+
+	done := make(chan error, 1)
+	go func() { done <- cache.SetWithPolicy(ctx, "key1", "new", WriteThrough) }()
+	select {
+	case <-done:
+		t.Fatal("Write-through must wait for the batch persisting the key")
+	case <-time.After(20 * time.Millisecond):
+	}
+	release()
+	require.NoError(t, <-done)
+
+	value, _ := db.value("key1")
+	assert.Equal(t, "new", value, "The older batch must not overwrite the write-through")
+}
@@ -0,0 +1,36 @@
+package multi_tier_caching
+
+import "errors"
+
+// ErrQueueFull is returned by writes rejected because the write queue is at capacity
+var ErrQueueFull = errors.New("write queue is full")
+
+// OverflowPolicy selects what Enqueue does when a bounded write queue is at capacity.
+// A write for a key that is already pending replaces it and is always accepted.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock waits for free space until the context is done
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropOldest discards the oldest pending write to make room
+	OverflowDropOldest
+	// OverflowDropNewest discards the incoming write
+	OverflowDropNewest
+	// OverflowFail rejects the incoming write with ErrQueueFull
+	OverflowFail
+)
+
+func (p OverflowPolicy) String() string {
+	switch p {
+	case OverflowBlock:
+		return "block"
+	case OverflowDropOldest:
+		return "drop-oldest"
+	case OverflowDropNewest:
+		return "drop-newest"
+	case OverflowFail:
+		return "fail"
+	default:
+		return "unknown"
+	}
+}